@@ -10,6 +10,80 @@ type Cache[Key any, Value any] interface {
 	Clear()
 }
 
+// ResultCache is implemented by caches that want to know what the keys they hold resolved to,
+// for instance to expire keys that were not found (see ErrNotFound) sooner than the others.
+// The Loader calls Resolve once the batch function returned the result of a key it set in the
+// cache, and when a key is primed.
+type ResultCache[Key any, Value any] interface {
+	Cache[Key, Value]
+	Resolve(ctx context.Context, key Key, result *Result[Value])
+}
+
+// AtomicCache is implemented by caches that can look a key up and set it if it's missing in a single
+// atomic operation. The Loader doesn't need to lock around such caches, so concurrent calls to Load
+// only contend inside the cache.
+type AtomicCache[Key any, Value any] interface {
+	Cache[Key, Value]
+	// GetOrSet returns the thunk at key and true if it exists. Otherwise it sets thunk at key
+	// and returns it and false.
+	GetOrSet(ctx context.Context, key Key, thunk Thunk[Value]) (Thunk[Value], bool)
+}
+
+// PrefetchCache is implemented by caches with a tier which is too slow to be looked up on every call
+// to Load, e.g. one shared over the network. The Loader calls Prefetch with the keys of each batch before
+// calling the batch function, which is then only given the keys Prefetch didn't resolve.
+type PrefetchCache[Key any, Value any] interface {
+	Cache[Key, Value]
+	// Prefetch returns a slice of results the same length as keys, where the
+	// result of a key is nil if it couldn't be resolved.
+	Prefetch(ctx context.Context, keys []Key) []*Result[Value]
+}
+
+// StaleCache is implemented by caches whose items become stale before they expire. The Loader returns
+// a stale item from Load at once, and reloads its key in the next batch in the background, replacing
+// the item once the batch function returned a result for it.
+type StaleCache[Key any, Value any] interface {
+	Cache[Key, Value]
+	// GetStale is like Get, but also reports whether the item it found is stale.
+	GetStale(ctx context.Context, key Key) (thunk Thunk[Value], found bool, stale bool)
+}
+
+// PredicateCache is implemented by caches which can delete the items whose key matches a predicate
+// (see Loader.ClearWhere).
+type PredicateCache[Key any, Value any] interface {
+	Cache[Key, Value]
+	// DeleteFunc deletes the items whose key match reports true for, and returns how many it deleted.
+	DeleteFunc(ctx context.Context, match func(Key) bool) int
+}
+
+// TagCache is implemented by caches which can tag their items, and delete every item with a tag at once
+// (see WithTagFunc and Loader.ClearTag). An item loses its tags when it is replaced.
+type TagCache[Key any, Value any] interface {
+	Cache[Key, Value]
+	// Tag gives tags to the item at key, if it exists.
+	Tag(ctx context.Context, key Key, tags []string)
+	// DeleteTag deletes the items with tag, and returns how many it deleted.
+	DeleteTag(ctx context.Context, tag string) int
+}
+
+// CacheUsage describes how an item found in a cache has been used.
+type CacheUsage struct {
+	// number of times the item was got since it was set, including this one
+	Hits int
+	// fraction of the time to live of the item which is left, 1 if it never expires
+	TTLLeft float64
+	// is the item stale (see StaleCache)?
+	Stale bool
+}
+
+// UsageCache is implemented by caches which track how their items are used, which the Loader needs
+// to reload the hot keys before their items expire (see WithRefreshAhead).
+type UsageCache[Key any, Value any] interface {
+	Cache[Key, Value]
+	// GetUsage is like Get, but also returns the usage of the item it found.
+	GetUsage(ctx context.Context, key Key) (Thunk[Value], CacheUsage, bool)
+}
+
 // NoCache implements Cache interface where all methods are noops.
 // This is useful for when you don't want to cache items but still
 // want to use a data loader
@@ -21,6 +95,11 @@ func (c *NoCache[Key, Value]) Get(context.Context, Key) (Thunk[Value], bool) { r
 // Set is a NOOP
 func (c *NoCache[Key, Value]) Set(context.Context, Key, Thunk[Value]) { return }
 
+// GetOrSet returns `value` as if it had been set, without storing it
+func (c *NoCache[Key, Value]) GetOrSet(_ context.Context, _ Key, value Thunk[Value]) (Thunk[Value], bool) {
+	return value, false
+}
+
 // Delete is a NOOP
 func (c *NoCache[Key, Value]) Delete(context.Context, Key) bool { return false }
 
@@ -0,0 +1,773 @@
+package dataloader
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func thunkOf[Value any](value Value) Thunk[Value] {
+	return func() (Value, error) { return value, nil }
+}
+
+func TestTTLCache(t *testing.T) {
+	t.Run("items expire after their time to live", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		cache := NewTTLCache[string, string](20 * time.Millisecond)
+		cache.Set(ctx, "expiring", thunkOf("1"))
+		cache.SetWithTTL(ctx, "forever", thunkOf("2"), 0)
+
+		if _, found := cache.Get(ctx, "expiring"); !found {
+			t.Error("Expected item to be found before it expires")
+		}
+		time.Sleep(30 * time.Millisecond)
+		if _, found := cache.Get(ctx, "expiring"); found {
+			t.Error("Expected item to expire")
+		}
+		if _, found := cache.Get(ctx, "forever"); !found {
+			t.Error("Expected item without time to live to never expire")
+		}
+	})
+
+	t.Run("sliding expiration extends the expiry on get", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		cache := NewTTLCache(40*time.Millisecond, WithSlidingExpiration[string, string]())
+		cache.Set(ctx, "1", thunkOf("1"))
+		for i := 0; i < 4; i++ {
+			time.Sleep(20 * time.Millisecond)
+			if _, found := cache.Get(ctx, "1"); !found {
+				t.Fatal("Expected item to be kept alive while it is used")
+			}
+		}
+	})
+
+	t.Run("items are stale before they expire", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		cache := NewTTLCache(20*time.Millisecond, WithStaleTTL[string, string](40*time.Millisecond))
+		cache.Set(ctx, "1", thunkOf("1"))
+
+		if _, found, stale := cache.GetStale(ctx, "1"); !found || stale {
+			t.Errorf("Expected a fresh item, got found %v stale %v", found, stale)
+		}
+		time.Sleep(30 * time.Millisecond)
+		if _, found, stale := cache.GetStale(ctx, "1"); !found || !stale {
+			t.Errorf("Expected a stale item, got found %v stale %v", found, stale)
+		}
+		time.Sleep(40 * time.Millisecond)
+		if _, found := cache.Get(ctx, "1"); found {
+			t.Error("Expected item to expire once it was stale for the stale time to live")
+		}
+	})
+
+	t.Run("usage of items is tracked", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		cache := NewTTLCache[string, string](40 * time.Millisecond)
+		cache.Set(ctx, "1", thunkOf("1"))
+		cache.SetWithTTL(ctx, "forever", thunkOf("2"), 0)
+
+		cache.Get(ctx, "1")
+		time.Sleep(20 * time.Millisecond)
+		_, usage, found := cache.GetUsage(ctx, "1")
+		if !found || usage.Hits != 2 || usage.TTLLeft <= 0 || usage.TTLLeft > 0.6 {
+			t.Errorf("Expected 2 hits and about half the time to live left, got %+v", usage)
+		}
+		if _, usage, _ := cache.GetUsage(ctx, "forever"); usage.TTLLeft != 1 {
+			t.Errorf("Expected all the time to live left for an item which never expires, got %+v", usage)
+		}
+
+		cache.Set(ctx, "1", thunkOf("1"))
+		if _, usage, _ := cache.GetUsage(ctx, "1"); usage.Hits != 1 {
+			t.Errorf("Expected hits to be reset when the item is set, got %+v", usage)
+		}
+	})
+
+	t.Run("time to live is derived from the result", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		cache := NewTTLCache(time.Hour,
+			WithNotFoundTTL[string, string](time.Millisecond),
+			WithResultTTL[string, string](func(r *Result[string]) time.Duration {
+				if r.Data == "short" {
+					return time.Millisecond
+				}
+				return 0
+			}))
+		loader := NewMapBatchedLoader(func(_ context.Context, keys []string) (map[string]string, error) {
+			return map[string]string{"short": "short", "long": "long"}, nil
+		}, WithCache[string, string](cache))
+		loader.LoadMany(ctx, []string{"short", "long", "missing"})()
+
+		time.Sleep(5 * time.Millisecond)
+		if _, found := cache.Get(ctx, "short"); found {
+			t.Error("Expected item to expire after the time to live of its result")
+		}
+		if _, found := cache.Get(ctx, "missing"); found {
+			t.Error("Expected not found item to expire after the not found time to live")
+		}
+		if _, found := cache.Get(ctx, "long"); !found {
+			t.Error("Expected item to keep the default time to live")
+		}
+	})
+
+	t.Run("items replaced while they are loaded keep their time to live", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		started := make(chan struct{})
+		release := make(chan struct{})
+		cache := NewTTLCache(time.Hour, WithNotFoundTTL[string, string](time.Millisecond))
+		loader := NewMapBatchedLoader(func(_ context.Context, keys []string) (map[string]string, error) {
+			close(started)
+			<-release
+			return nil, nil
+		}, WithCache[string, string](cache), WithWait[string, string](time.Millisecond))
+		thunk := loader.Load(ctx, "created")
+		<-started
+		loader.Replace(ctx, "created", "created")
+		close(release)
+		if _, err := thunk(); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected the load to resolve to ErrNotFound, got %v", err)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+		if _, found := cache.Get(ctx, "created"); !found {
+			t.Error("Expected the replaced item to keep the default time to live")
+		}
+	})
+
+	t.Run("janitor removes expired items until stopped", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		cache := NewTTLCache(time.Millisecond, WithJanitor[string, string](time.Millisecond))
+		defer cache.Stop()
+		cache.Set(ctx, "1", thunkOf("1"))
+		time.Sleep(20 * time.Millisecond)
+
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		if len(cache.items) != 0 {
+			t.Errorf("Expected janitor to remove expired items, got %d items", len(cache.items))
+		}
+	})
+}
+
+func TestLRUCache(t *testing.T) {
+	t.Run("evicts the least recently used items", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		var evicted []string
+		cache := NewLRUCache(2, WithEvictionCallback(func(key string, _ Thunk[string]) {
+			evicted = append(evicted, key)
+		}))
+		cache.Set(ctx, "1", thunkOf("1"))
+		cache.Set(ctx, "2", thunkOf("2"))
+		cache.Get(ctx, "1")
+		cache.Set(ctx, "3", thunkOf("3"))
+
+		if _, found := cache.Get(ctx, "2"); found {
+			t.Error("Expected least recently used item to be evicted")
+		}
+		if _, found := cache.Get(ctx, "1"); !found {
+			t.Error("Expected recently used item to be kept")
+		}
+		if len(evicted) != 1 || evicted[0] != "2" {
+			t.Errorf("Expected eviction callback to be called with %q, got %v", "2", evicted)
+		}
+		expected := CacheStats{Hits: 2, Misses: 1, Evictions: 1}
+		if stats := cache.Stats(); stats != expected {
+			t.Errorf("Expected stats %+v, got %+v", expected, stats)
+		}
+	})
+
+	t.Run("each load looks the key up once", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		cache := NewLRUCache[string, string](10)
+		identityLoader, _ := IDLoader(0, WithCache[string, string](cache))
+
+		identityLoader.Load(ctx, "1")()
+		identityLoader.Load(ctx, "1")()
+		expected := CacheStats{Hits: 1, Misses: 1}
+		if stats := cache.Stats(); stats != expected {
+			t.Errorf("Expected stats %+v, got %+v", expected, stats)
+		}
+	})
+
+	t.Run("capacity is the total cost of the resolved items", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		cache := NewLRUCache(10, WithCost(func(_ string, r *Result[string]) int64 {
+			return int64(len(r.Data))
+		}))
+		loader := NewBatchedLoader(func(_ context.Context, keys []string) []*Result[string] {
+			var results []*Result[string]
+			for _, key := range keys {
+				results = append(results, &Result[string]{key, nil})
+			}
+			return results
+		}, WithCache[string, string](cache))
+
+		loader.Load(ctx, "aaaa")()
+		loader.Load(ctx, "bbbb")()
+		loader.Load(ctx, "cccc")()
+		if cache.Len() != 2 {
+			t.Errorf("Expected 2 items of cost 4 to fit in a capacity of 10, got %d", cache.Len())
+		}
+		if _, found := cache.Get(ctx, "aaaa"); found {
+			t.Error("Expected oldest item to be evicted")
+		}
+	})
+}
+
+func TestTinyLFUCache(t *testing.T) {
+	t.Run("rejects items used less often than the ones it would evict", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		cache := NewTinyLFUCache[string, string](2)
+		// a fixed hash makes the frequency estimates of the keys deterministic
+		cache.hash = fnvHash
+		for i := 0; i < 5; i++ {
+			for _, key := range []string{"1", "2"} {
+				if _, found := cache.Get(ctx, key); !found {
+					cache.Set(ctx, key, thunkOf(key))
+				}
+			}
+		}
+
+		cache.Get(ctx, "one-off")
+		cache.Set(ctx, "one-off", thunkOf("one-off"))
+		if _, found := cache.Get(ctx, "one-off"); found {
+			t.Error("Expected item used once not to be admitted")
+		}
+		if stats := cache.Stats(); stats.Rejections != 1 || stats.Evictions != 0 {
+			t.Errorf("Expected one rejection and no eviction, got %+v", stats)
+		}
+
+		for i := 0; i < 10; i++ {
+			cache.Get(ctx, "hot")
+		}
+		cache.Set(ctx, "hot", thunkOf("hot"))
+		if _, found := cache.Get(ctx, "hot"); !found {
+			t.Error("Expected frequently used item to be admitted")
+		}
+		if cache.Len() != 2 {
+			t.Errorf("Expected cache to respect its capacity, got %d items", cache.Len())
+		}
+	})
+
+	t.Run("items which aren't admitted yet are shared by concurrent loads", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		cache := NewTinyLFUCache[string, string](2)
+		cache.hash = fnvHash
+		identityLoader, loadCalls := IDLoader(0, WithCache[string, string](cache))
+		for i := 0; i < 5; i++ {
+			identityLoader.LoadMany(ctx, []string{"1", "2"})()
+		}
+
+		future1 := identityLoader.Load(ctx, "new")
+		future2 := identityLoader.Load(ctx, "new")
+		future1()
+		future2()
+		expected := [][]string{{"1", "2"}, {"new"}}
+		if !reflect.DeepEqual(*loadCalls, expected) {
+			t.Errorf("Expected the key to be loaded once. Expected %#v, got %#v", expected, *loadCalls)
+		}
+		if _, found := cache.Get(ctx, "new"); found {
+			t.Error("Expected item used less often than the others to be rejected once resolved")
+		}
+		if stats := cache.Stats(); stats.Rejections != 1 || cache.Len() != 2 {
+			t.Errorf("Expected one rejection and the cache to respect its capacity, got %+v and %d items", stats, cache.Len())
+		}
+	})
+
+	t.Run("items kept aside are bounded by the capacity", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		cache := NewTinyLFUCache[string, string](1)
+		cache.hash = fnvHash
+		for i := 0; i < 5; i++ {
+			cache.Get(ctx, "hot")
+		}
+		cache.Set(ctx, "hot", thunkOf("hot"))
+		for _, key := range []string{"1", "2", "3"} {
+			if _, loaded := cache.GetOrSet(ctx, key, thunkOf(key)); loaded {
+				t.Errorf("Expected %q not to be loaded", key)
+			}
+		}
+
+		cache.mu.Lock()
+		pending := len(cache.pending)
+		cache.mu.Unlock()
+		if stats := cache.Stats(); pending != 1 || stats.Rejections != 2 {
+			t.Errorf("Expected one item kept aside and the others rejected, got %d kept aside and %+v", pending, stats)
+		}
+	})
+}
+
+// fnvHash is a fixed hash of key, so that the frequency estimates of a TinyLFUCache are deterministic.
+func fnvHash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+func TestShardedCache(t *testing.T) {
+	t.Run("concurrent loads of a key are batched once", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		cache := NewShardedCache[string, string](0)
+		identityLoader, loadCalls := IDLoader(0, WithCache[string, string](cache))
+
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				key := strconv.Itoa(i % 5)
+				if value, err := identityLoader.Load(ctx, key)(); err != nil || value != key {
+					t.Errorf("Expected %q, got %q (%v)", key, value, err)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		var keys []string
+		for _, call := range *loadCalls {
+			keys = append(keys, call...)
+		}
+		if len(keys) != 5 {
+			t.Errorf("Expected every key to be loaded once, got %v", keys)
+		}
+	})
+
+	t.Run("GetOrSet only sets missing keys", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		cache := NewShardedCache[string, string](2)
+		if _, loaded := cache.GetOrSet(ctx, "1", thunkOf("first")); loaded {
+			t.Error("Expected missing key to be set")
+		}
+		thunk, loaded := cache.GetOrSet(ctx, "1", thunkOf("second"))
+		if value, _ := thunk(); !loaded || value != "first" {
+			t.Errorf("Expected existing value to be returned, got %q", value)
+		}
+		if !cache.Delete(ctx, "1") || cache.Delete(ctx, "1") {
+			t.Error("Expected key to be deleted once")
+		}
+	})
+}
+
+// the built-in caches can be looked up and set atomically
+var (
+	_ AtomicCache[string, string] = &NoCache[string, string]{}
+	_ AtomicCache[string, string] = &InMemoryCache[string, string]{}
+	_ AtomicCache[string, string] = &TTLCache[string, string]{}
+	_ AtomicCache[string, string] = &LRUCache[string, string]{}
+	_ AtomicCache[string, string] = &TinyLFUCache[string, string]{}
+	_ AtomicCache[string, string] = &ShardedCache[string, string]{}
+	_ AtomicCache[string, string] = &TieredCache[string, string]{}
+	_ AtomicCache[string, string] = &EncodedCache[string, string]{}
+)
+
+// the built-in caches can delete items by predicate and by tag
+type tagCache interface {
+	PredicateCache[string, string]
+	TagCache[string, string]
+}
+
+func TestTagCaches(t *testing.T) {
+	caches := map[string]func() tagCache{
+		"InMemoryCache": func() tagCache { return NewCache[string, string]() },
+		"TTLCache":      func() tagCache { return NewTTLCache[string, string](time.Hour) },
+		"LRUCache":      func() tagCache { return NewLRUCache[string, string](10) },
+		"TinyLFUCache":  func() tagCache { return NewTinyLFUCache[string, string](10) },
+		"ShardedCache":  func() tagCache { return NewShardedCache[string, string](4) },
+		"TieredCache": func() tagCache {
+			return NewTieredCache[string, string](NewMemoryStore(), JSONCodec[string]{})
+		},
+		"EncodedCache": func() tagCache {
+			return NewEncodedCache[string, string](NewMemoryStore(), JSONCodec[string]{})
+		},
+	}
+
+	for name, newCache := range caches {
+		newCache := newCache
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			cache := newCache()
+			for _, key := range []string{"a1", "a2", "b1", "b2"} {
+				cache.Set(ctx, key, thunkOf(key))
+			}
+			cache.Tag(ctx, "a1", []string{"a", "1"})
+			cache.Tag(ctx, "a2", []string{"a"})
+			cache.Tag(ctx, "b1", []string{"1"})
+			cache.Tag(ctx, "missing", []string{"a"})
+
+			cache.Set(ctx, "a2", thunkOf("replaced"))
+			if deleted := cache.DeleteTag(ctx, "a"); deleted != 1 {
+				t.Errorf("Expected 1 item to be deleted by tag, got %d", deleted)
+			}
+			if _, found := cache.Get(ctx, "a1"); found {
+				t.Error("Expected tagged item to be deleted")
+			}
+			if _, found := cache.Get(ctx, "a2"); !found {
+				t.Error("Expected replaced item to lose its tags")
+			}
+			if deleted := cache.DeleteTag(ctx, "1"); deleted != 1 {
+				t.Errorf("Expected the other tags of deleted items to be removed, got %d deleted", deleted)
+			}
+
+			if deleted := cache.DeleteFunc(ctx, func(key string) bool { return key[0] == 'b' }); deleted != 1 {
+				t.Errorf("Expected 1 item to be deleted by predicate, got %d", deleted)
+			}
+			if _, found := cache.Get(ctx, "b2"); found {
+				t.Error("Expected matching item to be deleted")
+			}
+			if _, found := cache.Get(ctx, "a2"); !found {
+				t.Error("Expected other items to be kept")
+			}
+		})
+	}
+}
+
+func TestTieredCache(t *testing.T) {
+	t.Run("keys found in the remote tier are not passed to the batch function", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		store := NewMemoryStore()
+		newLoader := func() (*Loader[string, string], *[][]string) {
+			identityLoader, loadCalls := IDLoader(0,
+				WithCache[string, string](NewTieredCache[string, string](store, JSONCodec[string]{})),
+			)
+			return identityLoader, loadCalls
+		}
+
+		replica1, loadCalls1 := newLoader()
+		replica1.Load(ctx, "1")()
+		replica1.Load(ctx, "2")()
+
+		replica2, loadCalls2 := newLoader()
+		future1 := replica2.Load(ctx, "1")
+		future2 := replica2.Load(ctx, "3")
+		if value, err := future1(); err != nil || value != "1" {
+			t.Errorf("Expected %q, got %q (%v)", "1", value, err)
+		}
+		if value, err := future2(); err != nil || value != "3" {
+			t.Errorf("Expected %q, got %q (%v)", "3", value, err)
+		}
+
+		if len(*loadCalls1) != 2 {
+			t.Errorf("Expected first replica to load both keys, got %#v", *loadCalls1)
+		}
+		expected := [][]string{{"3"}}
+		if !reflect.DeepEqual(*loadCalls2, expected) {
+			t.Errorf("Expected second replica to only load missing keys. Expected %#v, got %#v", expected, *loadCalls2)
+		}
+	})
+
+	t.Run("delete removes the key from the remote tier", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		store := NewMemoryStore()
+		cache := NewTieredCache[string, string](store, JSONCodec[string]{}, WithRemoteKey[string, string](func(key string) string {
+			return "prefix:" + key
+		}))
+		cache.Set(ctx, "1", thunkOf("1"))
+		cache.Resolve(ctx, "1", &Result[string]{Data: "1"})
+		if _, found, _ := store.Get(ctx, "prefix:1"); !found {
+			t.Error("Expected resolved value to be written to the remote tier")
+		}
+
+		cache.Delete(ctx, "1")
+		if _, found, _ := store.Get(ctx, "prefix:1"); found {
+			t.Error("Expected key to be deleted from the remote tier")
+		}
+		if _, found := cache.Get(ctx, "1"); found {
+			t.Error("Expected key to be deleted from the local tier")
+		}
+	})
+
+	t.Run("keys cleared before they resolve are not written to the remote tier", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		store := NewMemoryStore()
+		cache := NewTieredCache[string, string](store, JSONCodec[string]{})
+		cache.Set(ctx, "1", thunkOf("1"))
+		cache.Clear()
+		cache.Resolve(ctx, "1", &Result[string]{Data: "1"})
+		if _, found, _ := store.Get(ctx, "1"); found {
+			t.Error("Expected the cleared key not to be written to the remote tier")
+		}
+	})
+}
+
+// binaryUser serializes itself like a protobuf message
+type binaryUser struct {
+	Name string
+}
+
+func (u *binaryUser) Marshal() ([]byte, error) { return []byte(u.Name), nil }
+
+func (u *binaryUser) Unmarshal(data []byte) error {
+	u.Name = string(data)
+	return nil
+}
+
+func TestCodecs(t *testing.T) {
+	t.Run("values survive a round trip", func(t *testing.T) {
+		t.Parallel()
+		type user struct{ Name string }
+		for name, codec := range map[string]Codec[user]{
+			"json": JSONCodec[user]{},
+			"gob":  GobCodec[user]{},
+		} {
+			data, err := codec.Marshal(user{Name: "alice"})
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			value, err := codec.Unmarshal(data)
+			if err != nil || value.Name != "alice" {
+				t.Errorf("%s: Expected %q, got %#v (%v)", name, "alice", value, err)
+			}
+		}
+
+		codec := BinaryCodec[*binaryUser]{}
+		data, err := codec.Marshal(&binaryUser{Name: "bob"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := codec.Unmarshal(data)
+		if err != nil || value.Name != "bob" {
+			t.Errorf("binary: Expected %q, got %#v (%v)", "bob", value, err)
+		}
+		if _, err := (BinaryCodec[int]{}).Marshal(1); err == nil {
+			t.Error("binary: Expected an error for values which don't marshal themselves")
+		}
+	})
+}
+
+// countingStore counts the reads of a MemoryStore
+type countingStore struct {
+	*MemoryStore
+	mu        sync.Mutex
+	gets      int
+	getMultis int
+}
+
+func (s *countingStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	s.gets++
+	s.mu.Unlock()
+	return s.MemoryStore.Get(ctx, key)
+}
+
+func (s *countingStore) GetMulti(ctx context.Context, keys []string) (map[string][]byte, error) {
+	s.mu.Lock()
+	s.getMultis++
+	s.mu.Unlock()
+	return s.MemoryStore.GetMulti(ctx, keys)
+}
+
+func (s *countingStore) counts() (gets, getMultis int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.gets, s.getMultis
+}
+
+func TestEncodedCache(t *testing.T) {
+	t.Run("results are decoded back from the store", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		store := NewMemoryStore()
+		cache := NewEncodedCache[string, string](store, GobCodec[string]{}, WithRemoteErrors[string, string](time.Minute))
+		loader := NewMapBatchedLoader(func(_ context.Context, keys []string) (map[string]string, error) {
+			return map[string]string{"1": "one"}, nil
+		}, WithCache[string, string](cache))
+		loader.LoadMany(ctx, []string{"1", "missing"})()
+		cache.Set(ctx, "failed", thunkOf(""))
+		cache.Resolve(ctx, "failed", &Result[string]{Error: errors.New("failure")})
+
+		// a new cache only sees what was encoded in the store
+		cache = NewEncodedCache[string, string](store, GobCodec[string]{})
+		results := cache.Prefetch(ctx, []string{"1", "missing", "failed", "unknown"})
+		if results[0] == nil || results[0].Data != "one" || results[0].Error != nil {
+			t.Errorf("Expected %q to be stored, got %#v", "one", results[0])
+		}
+		if results[1] == nil || !errors.Is(results[1].Error, ErrNotFound) {
+			t.Errorf("Expected not found marker to be stored, got %#v", results[1])
+		}
+		var cachedErr *CachedError
+		if results[2] == nil || !errors.As(results[2].Error, &cachedErr) || cachedErr.Message != "failure" {
+			t.Errorf("Expected *CachedError, got %#v", results[2])
+		}
+		if results[3] != nil {
+			t.Errorf("Expected unknown key not to be found, got %#v", results[3])
+		}
+	})
+
+	t.Run("keys of a batch are read from the store at once", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		store := &countingStore{MemoryStore: NewMemoryStore()}
+		replica1, _ := IDLoader(0, WithCache[string, string](NewEncodedCache[string, string](store, JSONCodec[string]{})))
+		replica1.LoadMany(ctx, []string{"1", "2"})()
+
+		replica2, loadCalls := IDLoader(0, WithCache[string, string](NewEncodedCache[string, string](store, JSONCodec[string]{})))
+		if values, _ := replica2.LoadMany(ctx, []string{"1", "2", "3"})(); !reflect.DeepEqual(values, []string{"1", "2", "3"}) {
+			t.Errorf("Expected the stored values, got %#v", values)
+		}
+		expected := [][]string{{"3"}}
+		if !reflect.DeepEqual(*loadCalls, expected) {
+			t.Errorf("Expected only the missing key to be loaded. Expected %#v, got %#v", expected, *loadCalls)
+		}
+		if gets, getMultis := store.counts(); gets != 0 || getMultis != 2 {
+			t.Errorf("Expected one read per batch, got %d gets and %d multi gets", gets, getMultis)
+		}
+	})
+
+	t.Run("clear deletes the keys stored by the cache", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		store := NewMemoryStore()
+		store.Set(ctx, "other", []byte("{}"), 0)
+		cache := NewEncodedCache[string, string](store, JSONCodec[string]{})
+		cache.Set(ctx, "1", thunkOf("1"))
+		cache.Resolve(ctx, "1", &Result[string]{Data: "1"})
+		cache.Clear()
+		if _, found, _ := store.Get(ctx, "1"); found {
+			t.Error("Expected the stored key to be deleted")
+		}
+		if _, found, _ := store.Get(ctx, "other"); !found {
+			t.Error("Expected the keys stored by other processes to be left")
+		}
+	})
+
+	t.Run("stored keys are deleted from the store by tag and by predicate", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		store := NewMemoryStore()
+		identityLoader, _ := IDLoader(0,
+			WithCache[string, string](NewEncodedCache[string, string](store, JSONCodec[string]{})),
+			WithTagFunc(func(key string, _ *Result[string]) []string {
+				return []string{key[:1]}
+			}),
+		)
+		identityLoader.LoadMany(ctx, []string{"a1", "b1", "c1"})()
+		identityLoader.ClearTag(ctx, "a")
+		identityLoader.ClearWhere(ctx, func(key string) bool { return key[0] == 'b' })
+
+		for key, expected := range map[string]bool{"a1": false, "b1": false, "c1": true} {
+			if _, found, _ := store.Get(ctx, key); found != expected {
+				t.Errorf("Expected %q to be stored: %v, got %v", key, expected, found)
+			}
+		}
+	})
+
+	t.Run("stored keys are remembered up to a capacity and until they expire", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		cache := NewEncodedCache[string, string](NewMemoryStore(), JSONCodec[string]{},
+			WithStoredKeys[string, string](2),
+			WithRemoteErrors[string, string](time.Millisecond),
+		)
+		for _, key := range []string{"1", "2", "3"} {
+			cache.Set(ctx, key, thunkOf(key))
+			cache.Resolve(ctx, key, &Result[string]{Data: key})
+			cache.Tag(ctx, key, []string{"all"})
+		}
+		cache.Set(ctx, "failed", thunkOf(""))
+		cache.Resolve(ctx, "failed", &Result[string]{Error: errors.New("failure")})
+		time.Sleep(5 * time.Millisecond)
+
+		cache.mu.Lock()
+		keys := cache.stored.keys(time.Now())
+		cache.mu.Unlock()
+		if expected := []string{"3"}; !reflect.DeepEqual(keys, expected) {
+			t.Errorf("Expected the oldest and the expired keys to be forgotten. Expected %#v, got %#v", expected, keys)
+		}
+		if deleted := cache.DeleteTag(ctx, "all"); deleted != 1 {
+			t.Errorf("Expected the tags of the forgotten keys to be removed, got %d keys deleted", deleted)
+		}
+	})
+
+	t.Run("errors are only stored with WithRemoteErrors", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		store := NewMemoryStore()
+		newLoader := func() (*Loader[string, string], *[][]string) {
+			return ErrorLoader(0, WithCache[string, string](NewEncodedCache[string, string](store, JSONCodec[string]{})))
+		}
+
+		replica1, _ := newLoader()
+		replica1.Load(ctx, "1")()
+		replica2, loadCalls := newLoader()
+		if _, err := replica2.Load(ctx, "1")(); err == nil || errors.As(err, new(*CachedError)) {
+			t.Errorf("Expected the error of the batch function, got %v", err)
+		}
+		if len(*loadCalls) != 1 {
+			t.Errorf("Expected the failed key to be loaded again, got %#v", *loadCalls)
+		}
+	})
+
+	t.Run("pending keys are not awaited by the cache", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		cache := NewEncodedCache[string, string](NewMemoryStore(), JSONCodec[string]{})
+		identityLoader, loadCalls := IDLoader(0, WithCache[string, string](cache), WithManualDispatch[string, string]())
+		future1 := identityLoader.Load(ctx, "1")
+		time.Sleep(10 * time.Millisecond)
+		future2 := identityLoader.Load(ctx, "2")
+		future1()
+		future2()
+
+		expected := [][]string{{"1", "2"}}
+		if !reflect.DeepEqual(*loadCalls, expected) {
+			t.Errorf("Expected a single batch. Expected %#v, got %#v", expected, *loadCalls)
+		}
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		if len(cache.pending) != 0 {
+			t.Errorf("Expected resolved keys not to stay in memory, got %d", len(cache.pending))
+		}
+	})
+
+	t.Run("keys deleted before they resolve are not stored", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		store := NewMemoryStore()
+		cache := NewEncodedCache[string, string](store, JSONCodec[string]{})
+		cache.Set(ctx, "1", thunkOf("1"))
+		cache.Set(ctx, "2", thunkOf("2"))
+		cache.Delete(ctx, "1")
+		cache.Clear()
+		cache.Resolve(ctx, "1", &Result[string]{Data: "1"})
+		cache.Resolve(ctx, "2", &Result[string]{Data: "2"})
+		for _, key := range []string{"1", "2"} {
+			if _, found, _ := store.Get(ctx, key); found {
+				t.Errorf("Expected %q not to be stored", key)
+			}
+		}
+	})
+
+	t.Run("results expire", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		cache := NewEncodedCache[string, string](NewMemoryStore(), JSONCodec[string]{}, WithRemoteTTL[string, string](time.Millisecond))
+		cache.Set(ctx, "1", thunkOf("1"))
+		cache.Resolve(ctx, "1", &Result[string]{Data: "1"})
+		time.Sleep(5 * time.Millisecond)
+		if results := cache.Prefetch(ctx, []string{"1"}); results[0] != nil {
+			t.Error("Expected result to expire")
+		}
+	})
+}
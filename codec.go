@@ -0,0 +1,176 @@
+package dataloader
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Codec serializes values so that they can be stored outside of the process, e.g. in a RemoteStore.
+type Codec[Value any] interface {
+	Marshal(Value) ([]byte, error)
+	Unmarshal([]byte) (Value, error)
+}
+
+// JSONCodec is a Codec encoding values as JSON.
+type JSONCodec[Value any] struct{}
+
+// Marshal encodes value as JSON
+func (JSONCodec[Value]) Marshal(value Value) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+// Unmarshal decodes a value from JSON
+func (JSONCodec[Value]) Unmarshal(data []byte) (Value, error) {
+	var value Value
+	err := json.Unmarshal(data, &value)
+	return value, err
+}
+
+// GobCodec is a Codec encoding values with encoding/gob.
+type GobCodec[Value any] struct{}
+
+// Marshal encodes value with gob
+func (GobCodec[Value]) Marshal(value Value) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(value)
+	return buf.Bytes(), err
+}
+
+// Unmarshal decodes a value with gob
+func (GobCodec[Value]) Unmarshal(data []byte) (Value, error) {
+	var value Value
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&value)
+	return value, err
+}
+
+// BinaryCodec is a Codec for values which serialize themselves, either by implementing
+// encoding.BinaryMarshaler and encoding.BinaryUnmarshaler, or with Marshal and Unmarshal methods
+// like the messages generated by gogo/protobuf or vtprotobuf. Values held by pointer, like
+// protobuf messages, are allocated before being unmarshaled.
+type BinaryCodec[Value any] struct{}
+
+// Marshal encodes value with its own marshal method
+func (BinaryCodec[Value]) Marshal(value Value) ([]byte, error) {
+	switch v := any(value).(type) {
+	case encoding.BinaryMarshaler:
+		return v.MarshalBinary()
+	case interface{ Marshal() ([]byte, error) }:
+		return v.Marshal()
+	default:
+		return nil, fmt.Errorf("dataloader: %T can't be marshaled by BinaryCodec", value)
+	}
+}
+
+// Unmarshal decodes a value with its own unmarshal method
+func (BinaryCodec[Value]) Unmarshal(data []byte) (Value, error) {
+	var value Value
+	target := any(&value)
+	if t := reflect.TypeOf(value); t != nil && t.Kind() == reflect.Pointer {
+		value = reflect.New(t.Elem()).Interface().(Value)
+		target = value
+	}
+
+	switch v := target.(type) {
+	case encoding.BinaryUnmarshaler:
+		return value, v.UnmarshalBinary(data)
+	case interface{ Unmarshal([]byte) error }:
+		return value, v.Unmarshal(data)
+	default:
+		return value, fmt.Errorf("dataloader: %T can't be unmarshaled by BinaryCodec", value)
+	}
+}
+
+// CachedError is the error a key resolves to when it is read from an encoded cache tier and
+// its result was an error other than ErrNotFound, which only its message is kept of.
+type CachedError struct {
+	Message string
+}
+
+func (e *CachedError) Error() string {
+	return e.Message
+}
+
+// kinds of encoded results
+const (
+	encodedValue byte = iota
+	encodedNotFound
+	encodedError
+)
+
+const encodedResultVersion byte = 1
+
+var errInvalidEncodedResult = errors.New("dataloader: invalid encoded result")
+
+// resultCodec serializes results: their value encoded with a Codec, or their kind of error,
+// along with their expiry.
+//
+// The layout is: version (1 byte), kind (1 byte), expiry in unix nanoseconds or 0 if the result
+// doesn't expire (8 bytes), followed by the encoded value or the error message.
+type resultCodec[Key any, Value any] struct {
+	codec Codec[Value]
+}
+
+func (c resultCodec[Key, Value]) encode(result *Result[Value], expires time.Time) ([]byte, error) {
+	var (
+		kind    = encodedValue
+		payload []byte
+		err     error
+	)
+	switch {
+	case result.NotFound():
+		kind = encodedNotFound
+	case result.Error != nil:
+		kind = encodedError
+		payload = []byte(result.Error.Error())
+	default:
+		payload, err = c.codec.Marshal(result.Data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var expiry uint64
+	if !expires.IsZero() {
+		expiry = uint64(expires.UnixNano())
+	}
+
+	data := make([]byte, 10, 10+len(payload))
+	data[0] = encodedResultVersion
+	data[1] = kind
+	binary.BigEndian.PutUint64(data[2:], expiry)
+	return append(data, payload...), nil
+}
+
+// decode returns the result encoded in data for key, and whether it has expired.
+func (c resultCodec[Key, Value]) decode(key Key, data []byte) (*Result[Value], bool, error) {
+	if len(data) < 10 || data[0] != encodedResultVersion {
+		return nil, false, errInvalidEncodedResult
+	}
+
+	if expiry := binary.BigEndian.Uint64(data[2:]); expiry != 0 && time.Now().UnixNano() > int64(expiry) {
+		return nil, true, nil
+	}
+
+	payload := data[10:]
+	switch data[1] {
+	case encodedValue:
+		value, err := c.codec.Unmarshal(payload)
+		if err != nil {
+			return nil, false, err
+		}
+		return &Result[Value]{Data: value}, false, nil
+	case encodedNotFound:
+		return NotFoundResult[Key, Value](key), false, nil
+	case encodedError:
+		return &Result[Value]{Error: &CachedError{Message: string(payload)}}, false, nil
+	default:
+		return nil, false, errInvalidEncodedResult
+	}
+}
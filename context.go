@@ -0,0 +1,54 @@
+package dataloader
+
+import (
+	"context"
+	"time"
+)
+
+// BatchContextFunc builds the context passed to the batch function from the loader's base context
+// (see WithBatchContext) and the contexts of the callers of Load that take part in the batch, in the
+// order they were added to the batch.
+type BatchContextFunc func(base context.Context, callers []context.Context) (context.Context, context.CancelFunc)
+
+// MergeContexts is the default BatchContextFunc.
+//
+// The returned context is only cancelled when base is. Its values are looked up in base, then in
+// each of the callers in turn, so request scoped values (e.g. tracing spans) of the callers are
+// visible to the batch function. If every caller has a deadline, the latest of them is set
+// on the returned context.
+func MergeContexts(base context.Context, callers []context.Context) (context.Context, context.CancelFunc) {
+	ctx := context.Context(&mergedContext{Context: base, callers: callers})
+
+	var latest time.Time
+	for _, caller := range callers {
+		deadline, ok := caller.Deadline()
+		if !ok {
+			return ctx, func() {}
+		}
+		if deadline.After(latest) {
+			latest = deadline
+		}
+	}
+	if latest.IsZero() {
+		return ctx, func() {}
+	}
+	return context.WithDeadline(ctx, latest)
+}
+
+// mergedContext is a context cancelled with its embedded context, whose values fall back to callers.
+type mergedContext struct {
+	context.Context
+	callers []context.Context
+}
+
+func (c *mergedContext) Value(key any) any {
+	if v := c.Context.Value(key); v != nil {
+		return v
+	}
+	for _, caller := range c.callers {
+		if v := caller.Value(key); v != nil {
+			return v
+		}
+	}
+	return nil
+}
@@ -4,7 +4,7 @@ package dataloader
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"runtime"
 	"sync"
 	"time"
@@ -32,6 +32,46 @@ type Interface[Key any, Value any] interface {
 // The keys passed to this function are guaranteed to be unique
 type BatchFunc[Key any, Value any] func(context.Context, []Key) []*Result[Value]
 
+// MapBatchFunc is a function, which when given a slice of keys, returns the values it found keyed by
+// the input keys. Unlike BatchFunc the results don't need to be in any particular order: keys missing
+// from the map resolve to a *NotFoundError, and a non-nil error is returned to every key in the batch.
+//
+// The keys passed to this function are guaranteed to be unique
+type MapBatchFunc[Key comparable, Value any] func(context.Context, []Key) (map[Key]Value, error)
+
+// batchFunc adapts fn to a BatchFunc, ordering the results after the keys.
+func (fn MapBatchFunc[Key, Value]) batchFunc() BatchFunc[Key, Value] {
+	return func(ctx context.Context, keys []Key) []*Result[Value] {
+		results := make([]*Result[Value], len(keys))
+
+		values, err := fn(ctx, keys)
+		if err != nil {
+			for i := range keys {
+				results[i] = &Result[Value]{Error: err}
+			}
+			return results
+		}
+
+		for i, key := range keys {
+			if value, ok := values[key]; ok {
+				results[i] = &Result[Value]{Data: value}
+			} else {
+				results[i] = NotFoundResult[Key, Value](key)
+			}
+		}
+		return results
+	}
+}
+
+// BatchPrefetchFunc is a function, which when given the keys of a batch before they are passed to the
+// BatchFunc, returns a slice of results the same length as the keys, where the result of a key is nil
+// if it couldn't be resolved. Only the keys it didn't resolve are passed on to the BatchFunc.
+type BatchPrefetchFunc[Key any, Value any] func(context.Context, []Key) []*Result[Value]
+
+// BatchWriteBackFunc is a function called with the keys passed to the BatchFunc and the results it returned,
+// once they have been delivered to the callers of Load.
+type BatchWriteBackFunc[Key any, Value any] func(context.Context, []Key, []*Result[Value])
+
 // Result is the data structure that a BatchFunc returns.
 // It contains the resolved data, and any errors that may have occurred while fetching the data.
 type Result[Value any] struct {
@@ -39,6 +79,17 @@ type Result[Value any] struct {
 	Error error
 }
 
+// NotFound reports whether the result is for a key that doesn't exist, that is whether
+// its error matches ErrNotFound.
+func (r *Result[Value]) NotFound() bool {
+	return errors.Is(r.Error, ErrNotFound)
+}
+
+// NotFoundResult returns the result a BatchFunc should return for a key that doesn't exist.
+func NotFoundResult[Key any, Value any](key Key) *Result[Value] {
+	return &Result[Value]{Error: &NotFoundError[Key]{Key: key}}
+}
+
 // ResultMany is used by the LoadMany method.
 // It contains a list of resolved data and a list of errors.
 // The lengths of the data list and error list will match, and elements at each index correspond to each other.
@@ -48,7 +99,7 @@ type ResultMany[Value any] struct {
 }
 
 // Loader implements the dataloader.Interface.
-type Loader[Key any, Value any] struct {
+type Loader[Key comparable, Value any] struct {
 	// the batch function to be used by this loader
 	batchFn BatchFunc[Key, Value]
 
@@ -59,10 +110,29 @@ type Loader[Key any, Value any] struct {
 	// implementation could be used as long as it implements the `Cache` interface.
 	cacheLock sync.Mutex
 	cache     Cache[Key, Value]
+	// reports whether a key that resolved with the given error should stay cached.
+	// Set to nil if you want every error to be cached.
+	cacheError func(error) bool
+	// returns the tags of the item of a key from the result it resolved to
+	tagFunc func(Key, *Result[Value]) []string
 	// should we clear the cache on each batch?
 	// this would allow batching but no long term caching
 	clearCacheOnBatch bool
 
+	// the pending results of the keys being loaded, whose thunks are cached. The thunks of later calls
+	// to Load wait on them, so that they return as soon as their caller's context is done.
+	pending *pendingResults[Key, Value]
+
+	// keys being reloaded in the background, and the requests reloading them. A request whose key was
+	// cleared meanwhile is removed, so that the reload doesn't bring back the item.
+	refreshLock sync.Mutex
+	refreshing  map[Key]*batchRequest[Key, Value]
+
+	// items used at least refreshMinHits times are reloaded once less than refreshAhead
+	// of their time to live is left. Set refreshAhead to 0 if you don't want them to be.
+	refreshAhead   float64
+	refreshMinHits int
+
 	// count of queued up items
 	count int
 
@@ -72,56 +142,249 @@ type Loader[Key any, Value any] struct {
 	// the amount of time to wait before triggering a batch
 	wait time.Duration
 
+	// decides when batches are dispatched. Defaults to a FixedWindowScheduler waiting for wait.
+	scheduler BatchScheduler
+	// should awaiting a thunk dispatch the batch of its key?
+	dispatchOnAwait bool
+
+	// bounds the number of batches in flight. Set to nil if you want it to be unbounded.
+	limiter *batchLimiter[Key, Value]
+
+	// the maximum amount of time a batch function may run. Set to 0 if you want it to be unbounded.
+	batchTimeout time.Duration
+
+	// how keys that failed are retried. Set to nil if you don't want them to be retried.
+	retry *RetryPolicy
+
+	// resolves keys before the batch function is called, and is given the results it returned
+	prefetch  BatchPrefetchFunc[Key, Value]
+	writeBack BatchWriteBackFunc[Key, Value]
+
+	// the context the batch contexts are derived from, and how the callers' contexts are merged into it
+	batchContext     context.Context
+	batchContextFunc BatchContextFunc
+
 	// lock to protect the batching operations
 	batchLock sync.Mutex
 
 	// current batcher
 	curBatcher *batcher[Key, Value]
 
-	// used to close the sleeper of the current batcher
-	endSleeper chan bool
+	// schedule of the current batcher
+	window BatchWindow
 
 	logger Logger
 
 	// can be set to trace calls to dataloader
 	tracer Tracer[Key, Value]
+
+	// propagates the clears of the cache to the loaders subscribed to the same channel
+	invalidator         Invalidator[Key]
+	invalidationChannel string
+	// identifies the invalidations published by this loader
+	id          string
+	unsubscribe func()
+	closeOnce   sync.Once
+}
+
+// LoaderStats describes the current state of a Loader.
+type LoaderStats struct {
+	// Window is the window of the next batch, if the scheduler dispatches batches
+	// after a window of time (see WindowScheduler).
+	Window time.Duration
+	// InFlightBatches and QueuedBatches are the number of batches whose batch function is running and
+	// waiting, if the number of batches in flight is bounded (see WithMaxConcurrentBatches).
+	InFlightBatches int
+	QueuedBatches   int
 }
 
 // Option allows for configuration of Loader fields.
-type Option[Key any, Value any] func(*Loader[Key, Value])
+type Option[Key comparable, Value any] func(*Loader[Key, Value])
 
 // WithCache sets the BatchedLoader cache. Defaults to InMemoryCache if a Cache is not set.
-func WithCache[Key any, Value any](c Cache[Key, Value]) Option[Key, Value] {
+func WithCache[Key comparable, Value any](c Cache[Key, Value]) Option[Key, Value] {
 	return func(l *Loader[Key, Value]) {
 		l.cache = c
 	}
 }
 
 // WithBatchCapacity sets the batch capacity. Default is 0 (unbounded).
-func WithBatchCapacity[Key any, Value any](c int) Option[Key, Value] {
+func WithBatchCapacity[Key comparable, Value any](c int) Option[Key, Value] {
 	return func(l *Loader[Key, Value]) {
 		l.batchCap = c
 	}
 }
 
 // WithInputCapacity sets the input capacity. Default is 1000.
-func WithInputCapacity[Key any, Value any](c int) Option[Key, Value] {
+func WithInputCapacity[Key comparable, Value any](c int) Option[Key, Value] {
 	return func(l *Loader[Key, Value]) {
 		l.inputCap = c
 	}
 }
 
 // WithWait sets the amount of time to wait before triggering a batch.
-// Default duration is 16 milliseconds.
-func WithWait[Key any, Value any](d time.Duration) Option[Key, Value] {
+// Default duration is 16 milliseconds. It is ignored if a scheduler is set (see WithScheduler).
+func WithWait[Key comparable, Value any](d time.Duration) Option[Key, Value] {
 	return func(l *Loader[Key, Value]) {
 		l.wait = d
 	}
 }
 
+// WithScheduler sets when batches are dispatched to the batch function, e.g. once no key was added
+// to them for some time (see IdleScheduler). A batch is dispatched early if it reaches the batch
+// capacity. Defaults to a FixedWindowScheduler waiting for the duration set by WithWait.
+func WithScheduler[Key comparable, Value any](scheduler BatchScheduler) Option[Key, Value] {
+	return func(l *Loader[Key, Value]) {
+		l.scheduler = scheduler
+	}
+}
+
+// WithManualDispatch only dispatches batches when Loader.Dispatch is called, e.g. once a level of a GraphQL
+// query has been resolved, or as soon as a thunk whose key is in the batch is awaited. Calls to Load only
+// enqueue their key, so that every key loaded before a thunk is awaited is batched together.
+func WithManualDispatch[Key comparable, Value any]() Option[Key, Value] {
+	return func(l *Loader[Key, Value]) {
+		l.scheduler = NewManualScheduler()
+		WithDispatchOnAwait[Key, Value]()(l)
+	}
+}
+
+// WithDispatchOnAwait dispatches a batch as soon as a thunk whose key is in it is awaited, rather than
+// waiting for the scheduler, so that code loading a few keys before awaiting them doesn't wait for the
+// full batch window. The batch still holds every key loaded before the await.
+func WithDispatchOnAwait[Key comparable, Value any]() Option[Key, Value] {
+	return func(l *Loader[Key, Value]) {
+		l.dispatchOnAwait = true
+	}
+}
+
+// WithMaxConcurrentBatches bounds the number of batches whose batch function runs at the same time to n.
+// The batches dispatched beyond it wait in a queue until one of them returns (see WithBatchQueue).
+// A batch which timed out (see WithBatchTimeout) is in flight until its batch function returns.
+func WithMaxConcurrentBatches[Key comparable, Value any](n int) Option[Key, Value] {
+	return func(l *Loader[Key, Value]) {
+		if l.limiter == nil {
+			l.limiter = newBatchLimiter[Key, Value](n)
+		}
+		l.limiter.max = n
+	}
+}
+
+// WithBatchQueue bounds the number of batches waiting for the batch function when the maximum number of
+// batches are in flight (see WithMaxConcurrentBatches) to size, and sets what happens to the batches
+// dispatched once the queue is full. Default is an unbounded queue.
+func WithBatchQueue[Key comparable, Value any](size int, policy QueueFullPolicy) Option[Key, Value] {
+	return func(l *Loader[Key, Value]) {
+		if l.limiter == nil {
+			l.limiter = newBatchLimiter[Key, Value](0)
+		}
+		l.limiter.queueCap = size
+		l.limiter.policy = policy
+	}
+}
+
+// WithBatchTimeout bounds how long a single call to the batch function may run.
+// The batch function is given a context whose deadline is the earliest of the deadlines of
+// the callers in the batch or d from the start of the batch. Once it elapses every key in
+// the batch resolves with a *BatchTimeoutError. Default is 0 (unbounded).
+func WithBatchTimeout[Key comparable, Value any](d time.Duration) Option[Key, Value] {
+	return func(l *Loader[Key, Value]) {
+		l.batchTimeout = d
+	}
+}
+
+// WithRetry retries the keys that failed with a retryable error according to policy.
+// Only the failed keys are passed to the batch function again, and each retry is traced
+// as a batch of its own (see BatchAttempt). Retries stop when the batch context is done.
+func WithRetry[Key comparable, Value any](policy RetryPolicy) Option[Key, Value] {
+	return func(l *Loader[Key, Value]) {
+		l.retry = &policy
+	}
+}
+
+// WithRefreshAhead reloads the keys of the items which were got from the cache at least minHits times
+// once less than threshold (a fraction between 0 and 1) of their time to live is left, so that hot keys
+// don't expire. The keys are reloaded in the background by the batch function, in the next batch.
+// It requires a cache which tracks how its items are used (see UsageCache), like TTLCache.
+func WithRefreshAhead[Key comparable, Value any](threshold float64, minHits int) Option[Key, Value] {
+	return func(l *Loader[Key, Value]) {
+		l.refreshAhead = threshold
+		l.refreshMinHits = minHits
+	}
+}
+
+// WithBatchPrefetch sets a function given the keys of each batch before the batch function, e.g. to look
+// them up in an external cache with a single multi-get. The batch function is only given the keys it didn't
+// resolve. It replaces the Prefetch method of the cache if the cache is a PrefetchCache.
+func WithBatchPrefetch[Key comparable, Value any](fn BatchPrefetchFunc[Key, Value]) Option[Key, Value] {
+	return func(l *Loader[Key, Value]) {
+		l.prefetch = fn
+	}
+}
+
+// WithBatchWriteBack sets a function given the keys passed to the batch function and the results
+// it returned, e.g. to write them back to an external cache. It is called once the results have been
+// delivered to the callers of Load.
+func WithBatchWriteBack[Key comparable, Value any](fn BatchWriteBackFunc[Key, Value]) Option[Key, Value] {
+	return func(l *Loader[Key, Value]) {
+		l.writeBack = fn
+	}
+}
+
+// WithBatchContext sets the context the batch function contexts are derived from.
+// Cancelling it cancels the context of every batch. Default is context.Background().
+func WithBatchContext[Key comparable, Value any](ctx context.Context) Option[Key, Value] {
+	return func(l *Loader[Key, Value]) {
+		l.batchContext = ctx
+	}
+}
+
+// WithBatchContextFunc sets how the contexts of the callers taking part in a batch are combined
+// into the context of the batch function. Default is MergeContexts.
+func WithBatchContextFunc[Key comparable, Value any](fn BatchContextFunc) Option[Key, Value] {
+	return func(l *Loader[Key, Value]) {
+		l.batchContextFunc = fn
+	}
+}
+
+// WithCacheErrors sets whether keys that resolved with an error stay in the cache.
+// When false they are removed from the cache once their batch completes, so the next
+// call to Load fetches them again. Default is true.
+func WithCacheErrors[Key comparable, Value any](cache bool) Option[Key, Value] {
+	return func(l *Loader[Key, Value]) {
+		if cache {
+			l.cacheError = nil
+		} else {
+			l.cacheError = func(error) bool { return false }
+		}
+	}
+}
+
+// WithErrorCachePolicy sets which errors stay in the cache: keys that resolved with an error
+// for which keep returns false are removed from the cache once their batch completes.
+// For instance, to only cache the keys that don't exist:
+//
+//	WithErrorCachePolicy[Key, Value](func(err error) bool {
+//		return errors.Is(err, ErrNotFound)
+//	})
+func WithErrorCachePolicy[Key comparable, Value any](keep func(err error) bool) Option[Key, Value] {
+	return func(l *Loader[Key, Value]) {
+		l.cacheError = keep
+	}
+}
+
+// WithTagFunc tags the item of each key in the cache with the tags fn returns for the result it resolved to,
+// e.g. the organisation of a user, so that every item with a tag can be cleared at once (see Loader.ClearTag).
+// It requires a cache which supports tags (see TagCache), like the caches of this package.
+func WithTagFunc[Key comparable, Value any](fn func(Key, *Result[Value]) []string) Option[Key, Value] {
+	return func(l *Loader[Key, Value]) {
+		l.tagFunc = fn
+	}
+}
+
 // WithClearCacheOnBatch allows batching of items but no long term caching.
 // It accomplishes this by clearing the cache after each batch operation.
-func WithClearCacheOnBatch[Key any, Value any]() Option[Key, Value] {
+func WithClearCacheOnBatch[Key comparable, Value any]() Option[Key, Value] {
 	return func(l *Loader[Key, Value]) {
 		l.cacheLock.Lock()
 		l.clearCacheOnBatch = true
@@ -129,15 +392,26 @@ func WithClearCacheOnBatch[Key any, Value any]() Option[Key, Value] {
 	}
 }
 
+// WithInvalidator publishes the keys cleared by Clear, ClearAll and ClearTag to channel, and clears
+// the keys published to channel by other loaders, which may live in other processes. This keeps the
+// caches of long lived loaders consistent across the replicas of a service. Keys cleared by ClearWhere
+// aren't published. Call Loader.Close to unsubscribe from channel.
+func WithInvalidator[Key comparable, Value any](invalidator Invalidator[Key], channel string) Option[Key, Value] {
+	return func(l *Loader[Key, Value]) {
+		l.invalidator = invalidator
+		l.invalidationChannel = channel
+	}
+}
+
 // withSilentLogger turns of log messages. It's used by the tests
-func WithLogger[Key any, Value any](logger Logger) Option[Key, Value] {
+func WithLogger[Key comparable, Value any](logger Logger) Option[Key, Value] {
 	return func(l *Loader[Key, Value]) {
 		l.logger = logger
 	}
 }
 
 // WithTracer allows tracing of calls to Load and LoadMany
-func WithTracer[Key any, Value any](tracer Tracer[Key, Value]) Option[Key, Value] {
+func WithTracer[Key comparable, Value any](tracer Tracer[Key, Value]) Option[Key, Value] {
 	return func(l *Loader[Key, Value]) {
 		l.tracer = tracer
 	}
@@ -154,8 +428,11 @@ type ThunkMany[Value any] func() ([]Value, []error)
 
 // type used to on input channel
 type batchRequest[Key any, Value any] struct {
-	key     Key
-	channel chan *Result[Value]
+	ctx    context.Context
+	key    Key
+	result *pendingResult[Value]
+	// is this a background reload of a stale or hot key?
+	refresh bool
 }
 
 // NewBatchedLoader constructs a new Loader with given options.
@@ -164,6 +441,7 @@ func NewBatchedLoader[Key comparable, Value any](batchFn BatchFunc[Key, Value],
 		batchFn:  batchFn,
 		inputCap: 1000,
 		wait:     16 * time.Millisecond,
+		pending:  newPendingResults[Key, Value](),
 	}
 
 	for _, apply := range opts {
@@ -179,151 +457,308 @@ func NewBatchedLoader[Key comparable, Value any](batchFn BatchFunc[Key, Value],
 		loader.tracer = &NoopTracer[Key, Value]{}
 	}
 
+	if loader.scheduler == nil {
+		loader.scheduler = NewFixedWindowScheduler(loader.wait)
+	}
+
+	if loader.batchContext == nil {
+		loader.batchContext = context.Background()
+	}
+
+	if loader.batchContextFunc == nil {
+		loader.batchContextFunc = MergeContexts
+	}
+
 	if loader.logger == nil {
 		loader.logger = &NoopLogger{}
 	}
 
+	if loader.limiter != nil {
+		loader.limiter.batchCap = loader.batchCap
+	}
+
+	if loader.invalidator != nil {
+		loader.subscribe()
+	}
+
 	return loader
 }
 
-// Load load/resolves the given key, returning a channel that will contain the value and error
+// NewMapBatchedLoader constructs a new Loader with given options, using a MapBatchFunc
+// to resolve the batches.
+func NewMapBatchedLoader[Key comparable, Value any](batchFn MapBatchFunc[Key, Value], opts ...Option[Key, Value]) *Loader[Key, Value] {
+	return NewBatchedLoader(batchFn.batchFunc(), opts...)
+}
+
+// Load load/resolves the given key, returning a thunk that will contain the value and error.
+// The thunk returns ctx.Err() as soon as ctx is done, while the batch keeps running for other callers.
+// The thunk of a key whose item has already resolved is taken from the cache as is.
 func (l *Loader[Key, Value]) Load(originalContext context.Context, key Key) Thunk[Value] {
 	ctx, finish := l.tracer.TraceLoad(originalContext, key)
 
-	c := make(chan *Result[Value], 1)
-	var result struct {
-		mu    sync.RWMutex
-		value *Result[Value]
+	result, cached, stale, hot := l.getOrSetPending(ctx, key)
+	if cached != nil {
+		defer finish(cached)
+		l.traceCacheHit(ctx, key, stale)
+		// stale and hot items are returned at once, and their key is reloaded in the background
+		if stale || hot {
+			l.refresh(key)
+		}
+		return cached
 	}
 
-	// lock to prevent duplicate keys coming in before item has been added to cache.
-	l.cacheLock.Lock()
-	if v, ok := l.cache.Get(ctx, key); ok {
-		defer finish(v)
-		defer l.cacheLock.Unlock()
-		return v
+	thunk := result.thunk(ctx)
+	defer finish(thunk)
+
+	// this is sent to batch fn. It contains the key and the pending result to resolve
+	b := l.enqueue(&batchRequest[Key, Value]{ctx: ctx, key: key, result: result})
+	if l.dispatchOnAwait {
+		result.scheduled(func() { l.dispatch(b) })
 	}
 
-	thunk := func() (Value, error) {
-		result.mu.RLock()
-		resultNotSet := result.value == nil
-		result.mu.RUnlock()
-
-		if resultNotSet {
-			result.mu.Lock()
-			if v, ok := <-c; ok {
-				result.value = v
-			}
-			result.mu.Unlock()
+	return thunk
+}
+
+// Stats returns the current state of the loader.
+func (l *Loader[Key, Value]) Stats() LoaderStats {
+	var stats LoaderStats
+	if windowScheduler, ok := l.scheduler.(WindowScheduler); ok {
+		stats.Window = windowScheduler.Window()
+	}
+	if l.limiter != nil {
+		stats.InFlightBatches, stats.QueuedBatches = l.limiter.stats()
+	}
+	return stats
+}
+
+// Dispatch calls the batch function with the keys loaded so far at once, without waiting for the scheduler.
+func (l *Loader[Key, Value]) Dispatch(_ context.Context) {
+	l.batchLock.Lock()
+	if l.curBatcher != nil {
+		l.curBatcher.end()
+		l.reset()
+	}
+	l.batchLock.Unlock()
+}
+
+// getCached looks key up in the cache if it is a StaleCache or a UsageCache, reporting whether the item it
+// found is stale, or hot and close to its expiry (see WithRefreshAhead). found is false for other caches.
+func (l *Loader[Key, Value]) getCached(ctx context.Context, key Key) (v Thunk[Value], found, stale, hot bool) {
+	if usageCache, ok := l.cache.(UsageCache[Key, Value]); ok && l.refreshAhead > 0 {
+		v, usage, found := usageCache.GetUsage(ctx, key)
+		if !found {
+			return nil, false, false, false
 		}
-		result.mu.RLock()
-		defer result.mu.RUnlock()
-		return result.value.Data, result.value.Error
+		hot = !usage.Stale && usage.Hits >= l.refreshMinHits && usage.TTLLeft < l.refreshAhead
+		return v, true, usage.Stale, hot
 	}
-	defer finish(thunk)
+	if staleCache, ok := l.cache.(StaleCache[Key, Value]); ok {
+		v, found, stale = staleCache.GetStale(ctx, key)
+		return v, found, stale, false
+	}
+	return nil, false, false, false
+}
 
-	l.cache.Set(ctx, key, thunk)
+// refresh reloads key in the next batch, unless it is already being reloaded. Its thunk
+// replaces the one in the cache once the batch function returned a result for it.
+// The reload is dropped if the queue of waiting batches is full, as it isn't awaited by
+// any caller (see WithBatchQueue).
+func (l *Loader[Key, Value]) refresh(key Key) {
+	if l.limiter != nil && l.limiter.queueFull() {
+		return
+	}
+
+	l.refreshLock.Lock()
+	if _, ok := l.refreshing[key]; ok {
+		l.refreshLock.Unlock()
+		return
+	}
+	// refreshes don't belong to any caller, so they don't take part in the batch deadline
+	req := &batchRequest[Key, Value]{
+		ctx:     l.batchContext,
+		key:     key,
+		result:  newPendingResult[Value](),
+		refresh: true,
+	}
+	if l.refreshing == nil {
+		l.refreshing = make(map[Key]*batchRequest[Key, Value])
+	}
+	l.refreshing[key] = req
+	l.refreshLock.Unlock()
+
+	l.enqueue(req)
+}
+
+// refreshed replaces the item of the key reloaded by req with the result of the reload, unless the
+// key was cleared or replaced since the reload started. A key which is no longer found is cached as
+// not found, or removed if the error caching policy doesn't keep ErrNotFound. A failed reload keeps
+// the item, which is still served until it expires.
+func (l *Loader[Key, Value]) refreshed(ctx context.Context, req *batchRequest[Key, Value], item *Result[Value]) {
+	l.refreshLock.Lock()
+	defer l.refreshLock.Unlock()
+	if l.refreshing[req.key] != req {
+		return
+	}
+	delete(l.refreshing, req.key)
+
+	if item == nil || (item.Error != nil && !item.NotFound()) {
+		return
+	}
+	if item.Error != nil && !l.keepError(item.Error) {
+		l.cacheLock.Lock()
+		l.cache.Delete(ctx, req.key)
+		l.cacheLock.Unlock()
+		return
+	}
+	l.cacheLock.Lock()
+	l.cache.Set(ctx, req.key, req.result.thunk(context.Background()))
 	l.cacheLock.Unlock()
+	l.cached(ctx, req.key, item)
+}
 
-	// this is sent to batch fn. It contains the key and the channel to return the
-	// the result on
-	req := &batchRequest[Key, Value]{key, c}
+// stopRefresh stops the reloads in the background of the keys match reports true for from replacing
+// their item, which was cleared or replaced.
+func (l *Loader[Key, Value]) stopRefresh(match func(Key) bool) {
+	l.refreshLock.Lock()
+	for key := range l.refreshing {
+		if match(key) {
+			delete(l.refreshing, key)
+		}
+	}
+	l.refreshLock.Unlock()
+}
+
+// traceCacheHit tells the tracer about a cache hit if it is a CacheTracer.
+func (l *Loader[Key, Value]) traceCacheHit(ctx context.Context, key Key, stale bool) {
+	if cacheTracer, ok := l.tracer.(CacheTracer[Key]); ok {
+		cacheTracer.TraceCacheHit(ctx, key, stale)
+	}
+}
+
+// enqueue adds req to the current batch, starting a new batch if needed, and returns the batch.
+// Reloads in the background don't wait for the queue of waiting batches, so that the call to Load
+// which found a stale or hot item isn't blocked (see BlockWhenFull).
+func (l *Loader[Key, Value]) enqueue(req *batchRequest[Key, Value]) *batcher[Key, Value] {
+	if l.limiter != nil && !req.refresh {
+		l.limiter.admit()
+	}
 
 	l.batchLock.Lock()
+	defer l.batchLock.Unlock()
+
 	// start the batch window if it hasn't already started.
 	if l.curBatcher == nil {
-		l.curBatcher = l.newBatcher()
+		b := l.newBatcher()
+		l.curBatcher = b
 		// start the current batcher batch function
-		go l.curBatcher.batch(originalContext)
-		// start a sleeper for the current batcher
-		l.endSleeper = make(chan bool)
-		go l.sleeper(l.curBatcher, l.endSleeper)
-	}
-
-	l.curBatcher.input <- req
-
-	// if we need to keep track of the count (max batch), then do so.
-	if l.batchCap > 0 {
-		l.count++
-		// if we hit our limit, force the batch to start
-		if l.count == l.batchCap {
-			// end the batcher synchronously here because another call to Load
-			// may concurrently happen and needs to go to a new batcher.
-			l.curBatcher.end()
-			// end the sleeper for the current batcher.
-			// this is to stop the goroutine without waiting for the
-			// sleeper timeout.
-			close(l.endSleeper)
-			l.reset()
-		}
+		go b.batch()
+		l.window = l.scheduler.Schedule(func() { l.dispatch(b) })
 	}
-	l.batchLock.Unlock()
 
-	return thunk
+	b := l.curBatcher
+	b.input <- req
+	l.count++
+
+	// if we hit our limit or the scheduler says so, force the batch to start
+	if l.window.Added(l.count) || (l.batchCap > 0 && l.count == l.batchCap) {
+		// end the batcher synchronously here because another call to Load
+		// may concurrently happen and needs to go to a new batcher.
+		b.end()
+		l.reset()
+	}
+	return b
 }
 
-// LoadMany loads mulitiple keys, returning a thunk (type: ThunkMany) that will resolve the keys passed in.
-func (l *Loader[Key, Value]) LoadMany(originalContext context.Context, keys []Key) ThunkMany[Value] {
-	ctx, finish := l.tracer.TraceLoadMany(originalContext, keys)
+// dispatch ends the batcher b, which starts its batch function. It is called by the scheduler.
+func (l *Loader[Key, Value]) dispatch(b *batcher[Key, Value]) {
+	// this is protected by the batchLock to avoid closing the batcher input
+	// channel while Load is inserting a request
+	l.batchLock.Lock()
+	b.end()
 
-	var (
-		length = len(keys)
-		data   = make([]Value, length)
-		errors = make([]error, length)
-		c      = make(chan *ResultMany[Value], 1)
-		wg     sync.WaitGroup
-	)
+	// We can end here also if the batcher has already been closed and a
+	// new one has been created. So reset the loader state only if the batcher
+	// is the current one
+	if l.curBatcher == b {
+		l.reset()
+	}
+	l.batchLock.Unlock()
+}
 
-	resolve := func(ctx context.Context, i int) {
-		defer wg.Done()
-		thunk := l.Load(ctx, keys[i])
-		result, err := thunk()
-		data[i] = result
-		errors[i] = err
+// getOrSetPending returns the thunk cached at key if there is one for a caller whose context is ctx,
+// and whether the item is stale or hot (see getCached). Otherwise it caches the thunk of a new pending
+// result for key and returns the pending result.
+func (l *Loader[Key, Value]) getOrSetPending(ctx context.Context, key Key) (result *pendingResult[Value], cached Thunk[Value], stale, hot bool) {
+	// the shard of key stays locked while the cache is looked up, so that the thunk of a pending
+	// result can't be found in the cache before the pending result is known.
+	shard := l.pending.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if v, found, stale, hot := l.getCached(ctx, key); found {
+		return nil, shard.thunk(ctx, key, v), stale, hot
 	}
 
-	wg.Add(length)
-	for i := range keys {
-		go resolve(ctx, i)
+	// the cached thunk isn't bound to the caller's context so that a cancelled caller
+	// doesn't affect the others waiting on the same key.
+	result = newPendingResult[Value]()
+	if v, loaded := l.getOrSet(ctx, key, result.thunk(context.Background())); loaded {
+		return nil, shard.thunk(ctx, key, v), false, false
 	}
+	shard.set(key, result)
+	return result, nil, false, false
+}
 
-	go func() {
-		wg.Wait()
-
-		// errs is nil unless there exists a non-nil error.
-		// This prevents dataloader from returning a slice of all-nil errors.
-		var errs []error
-		for _, e := range errors {
-			if e != nil {
-				errs = errors
-				break
-			}
-		}
+// getOrSet returns the thunk cached at key and true if there is one. Otherwise it caches thunk
+// at key and returns it and false. The cache is locked unless it is an AtomicCache.
+func (l *Loader[Key, Value]) getOrSet(ctx context.Context, key Key, thunk Thunk[Value]) (Thunk[Value], bool) {
+	if atomicCache, ok := l.cache.(AtomicCache[Key, Value]); ok {
+		return atomicCache.GetOrSet(ctx, key, thunk)
+	}
 
-		c <- &ResultMany[Value]{Data: data, Error: errs}
-		close(c)
-	}()
+	// lock to prevent duplicate keys coming in before item has been added to cache.
+	l.cacheLock.Lock()
+	defer l.cacheLock.Unlock()
+	if v, ok := l.cache.Get(ctx, key); ok {
+		return v, true
+	}
+	l.cache.Set(ctx, key, thunk)
+	return thunk, false
+}
+
+// LoadMany loads mulitiple keys, returning a thunk (type: ThunkMany) that will resolve the keys passed in.
+func (l *Loader[Key, Value]) LoadMany(originalContext context.Context, keys []Key) ThunkMany[Value] {
+	ctx, finish := l.tracer.TraceLoadMany(originalContext, keys)
 
-	var result struct {
-		mu    sync.RWMutex
-		value *ResultMany[Value]
+	// every key is enqueued before any is awaited, so that they are batched together
+	// even if awaiting a thunk dispatches its batch (see WithManualDispatch).
+	thunks := make([]Thunk[Value], len(keys))
+	for i, key := range keys {
+		thunks[i] = l.Load(ctx, key)
 	}
 
+	var (
+		once   sync.Once
+		result *ResultMany[Value]
+	)
 	thunkMany := func() ([]Value, []error) {
-		result.mu.RLock()
-		resultNotSet := result.value == nil
-		result.mu.RUnlock()
-
-		if resultNotSet {
-			result.mu.Lock()
-			if v, ok := <-c; ok {
-				result.value = v
+		once.Do(func() {
+			data := make([]Value, len(keys))
+			errs := make([]error, len(keys))
+			failed := false
+			for i, thunk := range thunks {
+				data[i], errs[i] = thunk()
+				failed = failed || errs[i] != nil
 			}
-			result.mu.Unlock()
-		}
-		result.mu.RLock()
-		defer result.mu.RUnlock()
-		return result.value.Data, result.value.Error
+
+			// errs is nil unless there exists a non-nil error.
+			// This prevents dataloader from returning a slice of all-nil errors.
+			if !failed {
+				errs = nil
+			}
+			result = &ResultMany[Value]{Data: data, Error: errs}
+		})
+		return result.Data, result.Error
 	}
 
 	defer finish(thunkMany)
@@ -332,59 +767,229 @@ func (l *Loader[Key, Value]) LoadMany(originalContext context.Context, keys []Ke
 
 // Clear clears the value at `key` from the cache, it it exsits. Returs self for method chaining
 func (l *Loader[Key, Value]) Clear(ctx context.Context, key Key) Interface[Key, Value] {
-	l.cacheLock.Lock()
-	l.cache.Delete(ctx, key)
-	l.cacheLock.Unlock()
+	invalidation := Invalidation[Key]{Keys: []Key{key}}
+	l.invalidate(ctx, invalidation)
+	l.publish(ctx, invalidation)
 	return l
 }
 
 // ClearAll clears the entire cache. To be used when some event results in unknown invalidations.
 // Returns self for method chaining.
 func (l *Loader[Key, Value]) ClearAll() Interface[Key, Value] {
+	invalidation := Invalidation[Key]{All: true}
+	l.invalidate(context.Background(), invalidation)
+	l.publish(context.Background(), invalidation)
+	return l
+}
+
+// ClearWhere clears the keys match reports true for from the cache. If the cache can't delete keys by
+// predicate (see PredicateCache), the entire cache is cleared. Returns self for method chaining.
+func (l *Loader[Key, Value]) ClearWhere(ctx context.Context, match func(Key) bool) Interface[Key, Value] {
+	l.stopRefresh(match)
+	l.pending.forget(match)
 	l.cacheLock.Lock()
-	l.cache.Clear()
+	if predicateCache, ok := l.cache.(PredicateCache[Key, Value]); ok {
+		predicateCache.DeleteFunc(ctx, match)
+	} else {
+		l.cache.Clear()
+	}
 	l.cacheLock.Unlock()
 	return l
 }
 
+// ClearTag clears the keys tagged with tag from the cache (see WithTagFunc). If the cache doesn't support
+// tags (see TagCache), the entire cache is cleared. Returns self for method chaining.
+func (l *Loader[Key, Value]) ClearTag(ctx context.Context, tag string) Interface[Key, Value] {
+	invalidation := Invalidation[Key]{Tags: []string{tag}}
+	l.invalidate(ctx, invalidation)
+	l.publish(ctx, invalidation)
+	return l
+}
+
+// Close unsubscribes the loader from the channel of its invalidator (see WithInvalidator).
+// The loader can still be used afterwards, but its cache no longer follows the other loaders.
+func (l *Loader[Key, Value]) Close() error {
+	l.closeOnce.Do(func() {
+		if l.unsubscribe != nil {
+			l.unsubscribe()
+		}
+	})
+	return nil
+}
+
 // Prime adds the provided key and value to the cache. If the key already exists, no change is made.
 // Returns self for method chaining
 func (l *Loader[Key, Value]) Prime(ctx context.Context, key Key, value Value) Interface[Key, Value] {
-	if _, ok := l.cache.Get(ctx, key); !ok {
-		thunk := func() (Value, error) {
-			return value, nil
-		}
-		l.cache.Set(ctx, key, thunk)
+	l.prime(ctx, key, &Result[Value]{Data: value}, false)
+	return l
+}
+
+// PrimeMany adds the provided keys and values to the cache. Keys which already exist are left unchanged.
+// Returns self for method chaining
+func (l *Loader[Key, Value]) PrimeMany(ctx context.Context, values map[Key]Value) Interface[Key, Value] {
+	for key, value := range values {
+		l.prime(ctx, key, &Result[Value]{Data: value}, false)
 	}
 	return l
 }
 
+// PrimeError adds the provided key to the cache, resolving to err. If the key already exists, no change is made.
+// Returns self for method chaining
+func (l *Loader[Key, Value]) PrimeError(ctx context.Context, key Key, err error) Interface[Key, Value] {
+	l.prime(ctx, key, &Result[Value]{Error: err}, false)
+	return l
+}
+
+// Replace sets the provided key and value in the cache, overwriting the key if it already exists,
+// e.g. after the value was modified. Returns self for method chaining
+func (l *Loader[Key, Value]) Replace(ctx context.Context, key Key, value Value) Interface[Key, Value] {
+	l.prime(ctx, key, &Result[Value]{Data: value}, true)
+	return l
+}
+
+// prime caches a thunk resolving to result at key, unless key already exists and overwrite is false.
+func (l *Loader[Key, Value]) prime(ctx context.Context, key Key, result *Result[Value], overwrite bool) {
+	thunk := func() (Value, error) {
+		return result.Data, result.Error
+	}
+
+	if overwrite {
+		l.stopRefresh(func(k Key) bool { return k == key })
+	}
+
+	// the load of key which may be in flight no longer updates its item (see resolved)
+	shard := l.pending.shard(key)
+	shard.mu.Lock()
+	if overwrite {
+		l.cacheLock.Lock()
+		l.cache.Set(ctx, key, thunk)
+		l.cacheLock.Unlock()
+	} else if _, loaded := l.getOrSet(ctx, key, thunk); loaded {
+		shard.mu.Unlock()
+		return
+	}
+	delete(shard.results, key)
+	shard.mu.Unlock()
+
+	l.cached(ctx, key, result)
+}
+
+// cached tells the cache what the item it holds at key resolved to: it is passed on to the cache
+// if it is a ResultCache, and tagged (see WithTagFunc).
+func (l *Loader[Key, Value]) cached(ctx context.Context, key Key, result *Result[Value]) {
+	// the cache may only decide to keep the item once it resolved (see TinyLFUCache)
+	if resultCache, ok := l.cache.(ResultCache[Key, Value]); ok {
+		resultCache.Resolve(ctx, key, result)
+	}
+	if tagCache, ok := l.cache.(TagCache[Key, Value]); ok && l.tagFunc != nil {
+		if tags := l.tagFunc(key, result); len(tags) > 0 {
+			tagCache.Tag(ctx, key, tags)
+		}
+	}
+}
+
 func (l *Loader[Key, Value]) reset() {
 	l.count = 0
 	l.curBatcher = nil
+	l.window.Stop()
+	l.window = nil
 
 	if l.clearCacheOnBatch {
 		l.cache.Clear()
+		l.pending.forget(func(Key) bool { return true })
 	}
 }
 
-type batcher[Key any, Value any] struct {
-	input    chan *batchRequest[Key, Value]
-	batchFn  BatchFunc[Key, Value]
-	finished bool
-	logger   Logger
-	tracer   Tracer[Key, Value]
+type batcher[Key comparable, Value any] struct {
+	input       chan *batchRequest[Key, Value]
+	batchFn     BatchFunc[Key, Value]
+	resolved    func(context.Context, []*batchRequest[Key, Value], []*Result[Value])
+	timeout     time.Duration
+	retry       *RetryPolicy
+	prefetch    BatchPrefetchFunc[Key, Value]
+	writeBack   BatchWriteBackFunc[Key, Value]
+	context     context.Context
+	contextFunc BatchContextFunc
+	observer    BatchObserver
+	limiter     *batchLimiter[Key, Value]
+	finished    bool
+	logger      Logger
+	tracer      Tracer[Key, Value]
 }
 
 // newBatcher returns a batcher for the current requests
 // all the batcher methods must be protected by a global batchLock
 func (l *Loader[Key, Value]) newBatcher() *batcher[Key, Value] {
+	prefetch := l.prefetch
+	if prefetchCache, ok := l.cache.(PrefetchCache[Key, Value]); ok && prefetch == nil {
+		prefetch = prefetchCache.Prefetch
+	}
+	observer, _ := l.scheduler.(BatchObserver)
+
 	return &batcher[Key, Value]{
-		input:   make(chan *batchRequest[Key, Value], l.inputCap),
-		batchFn: l.batchFn,
-		logger:  l.logger,
-		tracer:  l.tracer,
+		input:       make(chan *batchRequest[Key, Value], l.inputCap),
+		batchFn:     l.batchFn,
+		resolved:    l.resolved,
+		timeout:     l.batchTimeout,
+		retry:       l.retry,
+		prefetch:    prefetch,
+		writeBack:   l.writeBack,
+		context:     l.batchContext,
+		contextFunc: l.batchContextFunc,
+		observer:    observer,
+		limiter:     l.limiter,
+		logger:      l.logger,
+		tracer:      l.tracer,
+	}
+}
+
+// resolved is called by the batcher once the results of a batch are known.
+// Keys that resolved with a context error are removed from the cache, so that a
+// cancelled caller doesn't poison the key for later calls to Load, as are the keys
+// rejected by a full batch queue and the keys whose error shouldn't be cached
+// according to the error caching policy.
+// The cache is told what the other keys resolved to (see cached). Keys cleared, replaced
+// or primed while they were loaded are skipped.
+// A key reloaded in the background keeps its item, unless it reloaded successfully,
+// in which case the result replaces the item (see refreshed).
+func (l *Loader[Key, Value]) resolved(ctx context.Context, reqs []*batchRequest[Key, Value], items []*Result[Value]) {
+	for i, item := range items {
+		req := reqs[i]
+		if req.refresh {
+			l.refreshed(ctx, req, item)
+			continue
+		}
+
+		// a key cleared or replaced while it was loaded keeps its new item. The shard of the key stays
+		// locked while the cache is updated, so that the key can't be cleared or replaced meanwhile.
+		shard := l.pending.shard(req.key)
+		shard.mu.Lock()
+		if shard.results[req.key] == req.result {
+			delete(shard.results, req.key)
+			l.resolvedKey(ctx, req.key, item)
+		}
+		shard.mu.Unlock()
+	}
+}
+
+// resolvedKey updates the cache once key, which is still being loaded, resolved to item.
+func (l *Loader[Key, Value]) resolvedKey(ctx context.Context, key Key, item *Result[Value]) {
+	if item == nil {
+		return
+	}
+
+	if item.Error != nil && !l.keepError(item.Error) {
+		l.cacheLock.Lock()
+		l.cache.Delete(ctx, key)
+		l.cacheLock.Unlock()
+		return
 	}
+	l.cached(ctx, key, item)
+}
+
+// keepError reports whether a key that resolved with err should stay cached.
+func (l *Loader[Key, Value]) keepError(err error) bool {
+	return !isTransientError(err) && (l.cacheError == nil || l.cacheError(err))
 }
 
 // stop receiving input and process batch function
@@ -396,90 +1001,233 @@ func (b *batcher[Key, Value]) end() {
 }
 
 // execute the batch of all items in queue
-func (b *batcher[Key, Value]) batch(originalContext context.Context) {
+func (b *batcher[Key, Value]) batch() {
 	var (
-		keys     = make([]Key, 0)
-		reqs     = make([]*batchRequest[Key, Value], 0)
-		items    = make([]*Result[Value], 0)
-		panicErr interface{}
+		keys    = make([]Key, 0)
+		reqs    = make([]*batchRequest[Key, Value], 0)
+		callers = make([]context.Context, 0)
+		items   = make([]*Result[Value], 0)
 	)
 
 	for item := range b.input {
-		keys = append(keys, item.key)
 		reqs = append(reqs, item)
 	}
 
-	ctx, finish := b.tracer.TraceBatch(originalContext, keys)
-	defer finish(items)
+	// wait until the batch may run if too many batches are in flight. The batch
+	// stays in flight until the batch function returns (see callWithDeadline).
+	release := func() {}
+	var duplicates []duplicateRequest[Key, Value]
+	if b.limiter != nil {
+		w, err := b.limiter.acquire(reqs)
+		if err != nil {
+			b.reject(reqs, err)
+			return
+		}
+		if w == nil {
+			// merged into a waiting batch
+			return
+		}
+		reqs, duplicates = w.reqs, w.duplicates
+		release = b.limiter.release
+	}
 
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				panicErr = r
-				const size = 64 << 10
-				buf := make([]byte, size)
-				buf = buf[:runtime.Stack(buf, false)]
-				b.logger.Printf("Dataloader: Panic received in batch function: %v\n%s", panicErr, buf)
-			}
-		}()
-		items = b.batchFn(ctx, keys)
-	}()
+	for _, req := range reqs {
+		keys = append(keys, req.key)
+		callers = append(callers, req.ctx)
+	}
 
-	if panicErr != nil {
-		for _, req := range reqs {
-			req.channel <- &Result[Value]{Error: fmt.Errorf("Panic received in batch function: %v", panicErr)}
-			close(req.channel)
+	// the batch context isn't derived from any single caller, so that one caller
+	// giving up doesn't cancel the batch for everyone else.
+	batchContext, cancel := b.contextFunc(b.context, callers)
+	defer cancel()
+
+	ctx, finish := b.tracer.TraceBatch(batchContext, keys)
+	defer func() { finish(items) }()
+
+	if b.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = batchDeadline(ctx, reqs, b.timeout)
+		defer cancel()
+	}
+
+	// only the keys which couldn't be prefetched are passed to the batch function.
+	// Stale keys being reloaded skip the prefetch, which could return the same stale result.
+	items = b.callPrefetch(ctx, keys, reqs)
+	var (
+		missing     []int
+		missingKeys []Key
+		fetched     []*Result[Value]
+	)
+	for i, item := range items {
+		if item == nil {
+			missing = append(missing, i)
+			missingKeys = append(missingKeys, keys[i])
 		}
-		return
 	}
 
-	if len(items) != len(keys) {
-		err := &Result[Value]{Error: fmt.Errorf(`
-			The batch function supplied did not return an array of responses
-			the same length as the array of keys.
+	if len(missingKeys) > 0 {
+		start := time.Now()
+		if b.timeout > 0 {
+			fetched = b.callWithDeadline(ctx, missingKeys, release)
+		} else {
+			fetched = b.callWithRetry(ctx, missingKeys)
+			release()
+		}
+		if b.observer != nil {
+			b.observer.BatchDone(len(missingKeys), time.Since(start))
+		}
 
-			Keys:
-			%v
+		for j, i := range missing {
+			items[i] = fetched[j]
+		}
+	} else {
+		release()
+	}
+
+	// the requests merged into the batch for a key it already had resolve to the same item
+	resolvedReqs, resolvedItems := reqs, items
+	for _, duplicate := range duplicates {
+		resolvedReqs = append(resolvedReqs, duplicate.req)
+		resolvedItems = append(resolvedItems, items[duplicate.index])
+	}
+
+	// let the loader update the cache before the results are delivered, so that
+	// callers see the cache in its final state once their thunk returns.
+	b.resolved(ctx, resolvedReqs, resolvedItems)
+
+	for i, req := range resolvedReqs {
+		req.result.resolve(resolvedItems[i])
+	}
 
-			Values:
-			%v
-		`, keys, items)}
+	if b.writeBack != nil && len(missingKeys) > 0 {
+		b.callWriteBack(ctx, missingKeys, fetched)
+	}
+}
 
-		for _, req := range reqs {
-			req.channel <- err
-			close(req.channel)
+// reject resolves every request with err, without calling the batch function.
+func (b *batcher[Key, Value]) reject(reqs []*batchRequest[Key, Value], err error) {
+	items := errorResults[Value](len(reqs), err)
+	b.resolved(b.context, reqs, items)
+	for i, req := range reqs {
+		req.result.resolve(items[i])
+	}
+}
+
+// callWriteBack gives the results returned by the batch function to the write back function.
+func (b *batcher[Key, Value]) callWriteBack(ctx context.Context, keys []Key, items []*Result[Value]) {
+	defer func() {
+		if r := recover(); r != nil {
+			b.logger.Printf("Dataloader: Panic received in write back: %v", r)
 		}
+	}()
 
-		return
+	b.writeBack(ctx, keys, items)
+}
+
+// callPrefetch returns the results of the keys which could be resolved before calling the batch function
+// (see WithBatchPrefetch and PrefetchCache), with nil for the others and for the refreshed keys.
+func (b *batcher[Key, Value]) callPrefetch(ctx context.Context, keys []Key, reqs []*batchRequest[Key, Value]) (items []*Result[Value]) {
+	items = make([]*Result[Value], len(keys))
+	if b.prefetch == nil {
+		return items
 	}
 
+	var (
+		indexes []int
+		fetch   []Key
+	)
 	for i, req := range reqs {
-		req.channel <- items[i]
-		close(req.channel)
+		if !req.refresh {
+			indexes = append(indexes, i)
+			fetch = append(fetch, keys[i])
+		}
+	}
+	if len(fetch) == 0 {
+		return items
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			b.logger.Printf("Dataloader: Panic received in prefetch: %v", r)
+			items = make([]*Result[Value], len(keys))
+		}
+	}()
+
+	prefetched := b.prefetch(ctx, fetch)
+	if len(prefetched) != len(fetch) {
+		b.logger.Printf("Dataloader: prefetch returned %d results for %d keys", len(prefetched), len(fetch))
+		return items
+	}
+	for j, i := range indexes {
+		items[i] = prefetched[j]
+	}
+	return items
+}
+
+// call invokes the batch function, turning a panic or a wrong number of results
+// into an error for every key.
+func (b *batcher[Key, Value]) call(ctx context.Context, keys []Key) (items []*Result[Value]) {
+	defer func() {
+		if r := recover(); r != nil {
+			const size = 64 << 10
+			buf := make([]byte, size)
+			buf = buf[:runtime.Stack(buf, false)]
+			b.logger.Printf("Dataloader: Panic received in batch function: %v\n%s", r, buf)
+			items = errorResults[Value](len(keys), &BatchPanicError{Value: r, Stack: buf})
+		}
+	}()
+
+	items = b.batchFn(ctx, keys)
+	if len(items) != len(keys) {
+		items = errorResults[Value](len(keys), &BatchLengthMismatchError[Key]{
+			Expected: len(keys),
+			Actual:   len(items),
+			Keys:     keys,
+		})
 	}
+	return items
 }
 
-// wait the appropriate amount of time for the provided batcher
-func (l *Loader[Key, Value]) sleeper(b *batcher[Key, Value], close chan bool) {
+// callWithDeadline invokes the batch function like call, but gives up once the deadline of ctx
+// has passed, resolving every key with a *BatchTimeoutError. The batch function is left to
+// return on its own, and done is called once it did.
+func (b *batcher[Key, Value]) callWithDeadline(ctx context.Context, keys []Key, done func()) []*Result[Value] {
+	deadline, _ := ctx.Deadline()
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	c := make(chan []*Result[Value], 1)
+	go func() {
+		defer done()
+		c <- b.callWithRetry(ctx, keys)
+	}()
+
 	select {
-	// used by batch to close early. usually triggered by max batch size
-	case <-close:
-		return
-	// this will move this goroutine to the back of the callstack?
-	case <-time.After(l.wait):
+	case items := <-c:
+		return items
+	case <-timer.C:
+		return errorResults[Value](len(keys), &BatchTimeoutError{Deadline: deadline})
 	}
+}
 
-	// reset
-	// this is protected by the batchLock to avoid closing the batcher input
-	// channel while Load is inserting a request
-	l.batchLock.Lock()
-	b.end()
+// batchDeadline returns a copy of ctx with a deadline set to the earliest of the
+// deadlines of the batch participants or timeout from now.
+func batchDeadline[Key any, Value any](ctx context.Context, reqs []*batchRequest[Key, Value], timeout time.Duration) (context.Context, context.CancelFunc) {
+	deadline := time.Now().Add(timeout)
+	for _, req := range reqs {
+		if d, ok := req.ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+	}
+	return context.WithDeadline(ctx, deadline)
+}
 
-	// We can end here also if the batcher has already been closed and a
-	// new one has been created. So reset the loader state only if the batcher
-	// is the current one
-	if l.curBatcher == b {
-		l.reset()
+// errorResults returns n results all containing err.
+func errorResults[Value any](n int, err error) []*Result[Value] {
+	result := &Result[Value]{Error: err}
+	results := make([]*Result[Value], n)
+	for i := range results {
+		results[i] = result
 	}
-	l.batchLock.Unlock()
+	return results
 }
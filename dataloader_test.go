@@ -7,8 +7,11 @@ import (
 	"log"
 	"reflect"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 ///////////////////////////////////////////////////
@@ -53,6 +56,10 @@ func TestLoader(t *testing.T) {
 		if err == nil || err.Error() != "Panic received in batch function: Programming error" {
 			t.Error("Panic was not propagated as an error.")
 		}
+		var panicErr *BatchPanicError
+		if !errors.As(err, &panicErr) || panicErr.Value != "Programming error" || len(panicErr.Stack) == 0 {
+			t.Errorf("Expected *BatchPanicError carrying the panic value and stack, got %#v", err)
+		}
 	})
 
 	t.Run("test Load Method Panic Safety in multiple keys", func(t *testing.T) {
@@ -206,6 +213,13 @@ func TestLoader(t *testing.T) {
 			if err == nil {
 				t.Error("if number of results doesn't match keys, all keys should contain error")
 			}
+			var mismatch *BatchLengthMismatchError[string]
+			if !errors.As(err, &mismatch) {
+				t.Fatalf("Expected *BatchLengthMismatchError, got %#v", err)
+			}
+			if mismatch.Expected != n || mismatch.Actual != n-1 || !reflect.DeepEqual(mismatch.Keys, keys) {
+				t.Errorf("Expected mismatch of %d keys with %d results, got %#v", n, n-1, mismatch)
+			}
 		}
 
 		// TODO: expect to get some kind of warning
@@ -294,6 +308,48 @@ func TestLoader(t *testing.T) {
 		}
 	})
 
+	t.Run("prime is safe to call concurrently with load", func(t *testing.T) {
+		t.Parallel()
+		identityLoader, _ := IDLoader(0)
+		ctx := context.Background()
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				identityLoader.Prime(ctx, "A", "Cached")
+			}()
+			go func() {
+				defer wg.Done()
+				identityLoader.Load(ctx, "A")()
+			}()
+		}
+		wg.Wait()
+	})
+
+	t.Run("allows priming many values, errors and replacing values", func(t *testing.T) {
+		t.Parallel()
+		identityLoader, loadCalls := IDLoader(0)
+		ctx := context.Background()
+		primeErr := errors.New("primed error")
+		identityLoader.Prime(ctx, "C", "C")
+		identityLoader.PrimeMany(ctx, map[string]string{"A": "Cached A", "B": "Cached B", "C": "Not replaced"})
+		identityLoader.PrimeError(ctx, "E", primeErr)
+		identityLoader.Replace(ctx, "B", "Replaced B")
+
+		values, errs := identityLoader.LoadMany(ctx, []string{"A", "B", "C", "E"})()
+		expected := []string{"Cached A", "Replaced B", "C", ""}
+		if !reflect.DeepEqual(values, expected) {
+			t.Errorf("did not use primed values. Expected %#v, got %#v", expected, values)
+		}
+		if len(errs) != 4 || errs[3] != primeErr {
+			t.Errorf("did not use primed error. Expected %v, got %v", primeErr, errs)
+		}
+		if len(*loadCalls) != 0 {
+			t.Errorf("Expected no batch, got %#v", *loadCalls)
+		}
+	})
+
 	t.Run("allows clear value in cache", func(t *testing.T) {
 		t.Parallel()
 		identityLoader, loadCalls := IDLoader(0)
@@ -425,6 +481,1083 @@ func TestLoader(t *testing.T) {
 		}
 	})
 
+	t.Run("map batch function resolves keys in order", func(t *testing.T) {
+		t.Parallel()
+		mapLoader, loadCalls := MapLoader(0)
+		ctx := context.Background()
+		results, errs := mapLoader.LoadMany(ctx, []string{"1", "2", "3"})()
+		if errs != nil {
+			t.Errorf("Expected no errors, got %v", errs)
+		}
+		if !reflect.DeepEqual(results, []string{"1", "2", "3"}) {
+			t.Errorf("map loader didn't return the right values. Expected %#v, got %#v", []string{"1", "2", "3"}, results)
+		}
+		if len(*loadCalls) != 1 {
+			t.Errorf("did not batch queries. Expected 1 call, got %d", len(*loadCalls))
+		}
+	})
+
+	t.Run("map batch function resolves missing keys to ErrNotFound", func(t *testing.T) {
+		t.Parallel()
+		mapLoader, _ := MapLoader(0)
+		ctx := context.Background()
+		_, err := mapLoader.Load(ctx, "missing")()
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+		var notFound *NotFoundError[string]
+		if !errors.As(err, &notFound) || notFound.Key != "missing" {
+			t.Errorf("Expected *NotFoundError for key %q, got %#v", "missing", err)
+		}
+	})
+
+	t.Run("map batch function error is returned for every key", func(t *testing.T) {
+		t.Parallel()
+		testErr := errors.New("map error")
+		mapLoader := NewMapBatchedLoader(func(_ context.Context, keys []string) (map[string]string, error) {
+			return nil, testErr
+		})
+		ctx := context.Background()
+		_, errs := mapLoader.LoadMany(ctx, []string{"1", "2"})()
+		if len(errs) != 2 || errs[0] != testErr || errs[1] != testErr {
+			t.Errorf("Expected the batch error for every key, got %v", errs)
+		}
+	})
+
+	t.Run("thunk returns when the context is cancelled", func(t *testing.T) {
+		t.Parallel()
+		release := make(chan struct{})
+		blockingLoader, _ := BlockingLoader(release)
+		defer close(release)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		future1 := blockingLoader.Load(ctx, "1")
+		future2 := blockingLoader.LoadMany(ctx, []string{"2"})
+		cancel()
+
+		if _, err := future1(); err != context.Canceled {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+		if _, errs := future2(); len(errs) != 1 || errs[0] != context.Canceled {
+			t.Errorf("Expected context.Canceled, got %v", errs)
+		}
+	})
+
+	t.Run("cancelled caller does not affect other callers of the same key", func(t *testing.T) {
+		t.Parallel()
+		release := make(chan struct{})
+		blockingLoader, _ := BlockingLoader(release)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		future1 := blockingLoader.Load(ctx, "1")
+		future2 := blockingLoader.Load(context.Background(), "1")
+		cancel()
+		if _, err := future1(); err != context.Canceled {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+
+		close(release)
+		value, err := future2()
+		if err != nil || value != "1" {
+			t.Errorf("Expected %q, got %q (%v)", "1", value, err)
+		}
+	})
+
+	t.Run("callers of a key being loaded return when their context is cancelled", func(t *testing.T) {
+		t.Parallel()
+		release := make(chan struct{})
+		blockingLoader, loadCalls := BlockingLoader(release)
+
+		future1 := blockingLoader.Load(context.Background(), "1")
+		ctx, cancel := context.WithCancel(context.Background())
+		future2 := blockingLoader.Load(ctx, "1")
+		cancel()
+		if _, err := future2(); err != context.Canceled {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+
+		close(release)
+		if value, err := future1(); err != nil || value != "1" {
+			t.Errorf("Expected %q, got %q (%v)", "1", value, err)
+		}
+		if value, err := blockingLoader.Load(ctx, "1")(); err != nil || value != "1" {
+			t.Errorf("Expected the resolved item to be returned, got %q (%v)", value, err)
+		}
+		if len(*loadCalls) != 1 {
+			t.Errorf("Expected the key to be loaded once, got %#v", *loadCalls)
+		}
+	})
+
+	t.Run("context errors are not cached", func(t *testing.T) {
+		t.Parallel()
+		var mu sync.Mutex
+		var calls int
+		loader := NewBatchedLoader(func(ctx context.Context, keys []string) []*Result[string] {
+			mu.Lock()
+			calls++
+			// the first call gives up as if its context had expired
+			var err error
+			if calls == 1 {
+				err = context.DeadlineExceeded
+			}
+			mu.Unlock()
+			var results []*Result[string]
+			for _, key := range keys {
+				results = append(results, &Result[string]{key, err})
+			}
+			return results
+		})
+
+		ctx := context.Background()
+		if _, err := loader.Load(ctx, "1")(); err != context.DeadlineExceeded {
+			t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+		}
+		// wait for the batch to be resolved and evicted
+		for i := 0; i < 100; i++ {
+			if _, found := loader.cache.Get(ctx, "1"); !found {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		value, err := loader.Load(ctx, "1")()
+		if err != nil || value != "1" {
+			t.Errorf("Expected %q, got %q (%v)", "1", value, err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if calls != 2 {
+			t.Errorf("Expected the batch function to be called again, got %d calls", calls)
+		}
+	})
+
+	t.Run("batch context is detached from the callers", func(t *testing.T) {
+		t.Parallel()
+		type ctxKey struct{}
+		errs := make(chan error, 1)
+		values := make(chan any, 1)
+		release := make(chan struct{})
+		loader := NewBatchedLoader(func(ctx context.Context, keys []string) []*Result[string] {
+			<-release
+			errs <- ctx.Err()
+			values <- ctx.Value(ctxKey{})
+			var results []*Result[string]
+			for _, key := range keys {
+				results = append(results, &Result[string]{key, nil})
+			}
+			return results
+		})
+
+		ctx1, cancel := context.WithCancel(context.Background())
+		ctx2 := context.WithValue(context.Background(), ctxKey{}, "second")
+		future1 := loader.Load(ctx1, "1")
+		future2 := loader.Load(ctx2, "2")
+		cancel()
+		close(release)
+
+		if _, err := future1(); err != context.Canceled {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+		if value, err := future2(); err != nil || value != "2" {
+			t.Errorf("Expected %q, got %q (%v)", "2", value, err)
+		}
+		if err := <-errs; err != nil {
+			t.Errorf("Expected the batch context not to be cancelled, got %v", err)
+		}
+		if value := <-values; value != "second" {
+			t.Errorf("Expected the batch context to carry the values of every caller, got %v", value)
+		}
+	})
+
+	t.Run("batch timeout resolves keys with a timeout error", func(t *testing.T) {
+		t.Parallel()
+		release := make(chan struct{})
+		defer close(release)
+		loader := NewBatchedLoader(func(_ context.Context, keys []string) []*Result[string] {
+			<-release
+			return nil
+		}, WithBatchTimeout[string, string](10*time.Millisecond))
+
+		_, errs := loader.LoadMany(context.Background(), []string{"1", "2"})()
+		for _, err := range errs {
+			var timeoutErr *BatchTimeoutError
+			if !errors.As(err, &timeoutErr) || !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("Expected *BatchTimeoutError, got %#v", err)
+			}
+		}
+		if len(errs) != 2 {
+			t.Errorf("Expected an error for every key, got %v", errs)
+		}
+	})
+
+	t.Run("batch deadline is the earliest caller deadline", func(t *testing.T) {
+		t.Parallel()
+		deadlines := make(chan time.Time, 1)
+		loader := NewBatchedLoader(func(ctx context.Context, keys []string) []*Result[string] {
+			deadline, _ := ctx.Deadline()
+			deadlines <- deadline
+			var results []*Result[string]
+			for _, key := range keys {
+				results = append(results, &Result[string]{key, nil})
+			}
+			return results
+		}, WithBatchTimeout[string, string](time.Hour))
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		expected, _ := ctx.Deadline()
+		future1 := loader.Load(context.Background(), "1")
+		future2 := loader.Load(ctx, "2")
+		if _, err := future1(); err != nil {
+			t.Error(err.Error())
+		}
+		if _, err := future2(); err != nil {
+			t.Error(err.Error())
+		}
+		if deadline := <-deadlines; !deadline.Equal(expected) {
+			t.Errorf("Expected batch deadline %v, got %v", expected, deadline)
+		}
+	})
+
+	t.Run("retries only the keys that failed", func(t *testing.T) {
+		t.Parallel()
+		tracer := &attemptTracer{}
+		var mu sync.Mutex
+		var loadCalls [][]string
+		loader := NewBatchedLoader(func(_ context.Context, keys []string) []*Result[string] {
+			mu.Lock()
+			loadCalls = append(loadCalls, keys)
+			attempt := len(loadCalls)
+			mu.Unlock()
+			var results []*Result[string]
+			for _, key := range keys {
+				var err error
+				if key == "2" && attempt < 3 {
+					err = errors.New("transient error")
+				}
+				results = append(results, &Result[string]{key, err})
+			}
+			return results
+		}, WithRetry[string, string](RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Jitter: 0.5}),
+			WithTracer[string, string](tracer))
+
+		values, errs := loader.LoadMany(context.Background(), []string{"1", "2"})()
+		if errs != nil || !reflect.DeepEqual(values, []string{"1", "2"}) {
+			t.Errorf("Expected the failed key to be retried, got %v (%v)", values, errs)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		expected := [][]string{{"1", "2"}, {"2"}, {"2"}}
+		if !reflect.DeepEqual(loadCalls, expected) {
+			t.Errorf("did not retry the failed keys. Expected %#v, got %#v", expected, loadCalls)
+		}
+		if attempts := tracer.Attempts(); !reflect.DeepEqual(attempts, []int{1, 2, 3}) {
+			t.Errorf("Expected every attempt to be traced, got %v", attempts)
+		}
+	})
+
+	t.Run("does not retry errors that are not retryable", func(t *testing.T) {
+		t.Parallel()
+		loader, loadCalls := ErrorLoader(0, WithRetry[string, string](RetryPolicy{
+			MaxAttempts: 3,
+			Retryable:   func(error) bool { return false },
+		}))
+		_, errs := loader.LoadMany(context.Background(), []string{"1", "2"})()
+		if len(errs) != 2 {
+			t.Errorf("Expected an error for every key, got %v", errs)
+		}
+		if len(*loadCalls) != 1 {
+			t.Errorf("Expected no retries, got %#v", *loadCalls)
+		}
+	})
+
+	t.Run("errors are not cached with WithCacheErrors(false)", func(t *testing.T) {
+		t.Parallel()
+		loader, loadCalls := ErrorLoader(0, WithCacheErrors[string, string](false))
+		ctx := context.Background()
+		_, err1 := loader.Load(ctx, "1")()
+		_, err2 := loader.Load(ctx, "1")()
+		if err1 == nil || err2 == nil {
+			t.Errorf("Expected errors, got %v and %v", err1, err2)
+		}
+		expected := [][]string{{"1"}, {"1"}}
+		if !reflect.DeepEqual(*loadCalls, expected) {
+			t.Errorf("did not load the failed key again. Expected %#v, got %#v", expected, *loadCalls)
+		}
+	})
+
+	t.Run("failed keys replaced while they are loaded keep their new item", func(t *testing.T) {
+		t.Parallel()
+		started := make(chan struct{})
+		release := make(chan struct{})
+		loader := NewBatchedLoader(func(_ context.Context, keys []string) []*Result[string] {
+			close(started)
+			<-release
+			results := make([]*Result[string], len(keys))
+			for i := range keys {
+				results[i] = &Result[string]{Error: errors.New("unavailable")}
+			}
+			return results
+		}, WithCacheErrors[string, string](false), WithWait[string, string](time.Millisecond))
+
+		ctx := context.Background()
+		thunk := loader.Load(ctx, "1")
+		<-started
+		loader.Replace(ctx, "1", "fresh")
+		close(release)
+		if _, err := thunk(); err == nil {
+			t.Errorf("Expected the load to fail")
+		}
+		if value, err := loader.Load(ctx, "1")(); value != "fresh" || err != nil {
+			t.Errorf("Expected the replaced item to be kept, got %q and %v", value, err)
+		}
+	})
+
+	t.Run("errors kept by the error cache policy stay cached", func(t *testing.T) {
+		t.Parallel()
+		mapLoader, loadCalls := MapLoader(0,
+			WithErrorCachePolicy[string, string](func(err error) bool {
+				return errors.Is(err, ErrNotFound)
+			}),
+		)
+		ctx := context.Background()
+		mapLoader.Load(ctx, "missing")()
+		_, err := mapLoader.Load(ctx, "missing")()
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+		if len(*loadCalls) != 1 {
+			t.Errorf("Expected the not found key to stay cached, got %#v", *loadCalls)
+		}
+	})
+
+	t.Run("result caches are told what keys resolved to", func(t *testing.T) {
+		t.Parallel()
+		cache := &resultRecordingCache{InMemoryCache: NewCache[string, string](), results: map[string]*Result[string]{}}
+		mapLoader, _ := MapLoader(0, WithCache[string, string](cache))
+		ctx := context.Background()
+		mapLoader.Prime(ctx, "A", "Cached")
+		mapLoader.LoadMany(ctx, []string{"1", "missing"})()
+
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		if r := cache.results["A"]; r == nil || r.Data != "Cached" {
+			t.Errorf("Expected primed key to be resolved, got %#v", r)
+		}
+		if r := cache.results["1"]; r == nil || r.Data != "1" || r.NotFound() {
+			t.Errorf("Expected key to be resolved, got %#v", r)
+		}
+		if r := cache.results["missing"]; r == nil || !r.NotFound() {
+			t.Errorf("Expected key to be resolved as not found, got %#v", r)
+		}
+	})
+
+	t.Run("result caches are not told what keys replaced while they are loaded resolved to", func(t *testing.T) {
+		t.Parallel()
+		started := make(chan struct{})
+		release := make(chan struct{})
+		cache := &resultRecordingCache{InMemoryCache: NewCache[string, string](), results: map[string]*Result[string]{}}
+		loader := NewBatchedLoader(func(_ context.Context, keys []string) []*Result[string] {
+			close(started)
+			<-release
+			results := make([]*Result[string], len(keys))
+			for i, key := range keys {
+				results[i] = &Result[string]{Data: key}
+			}
+			return results
+		},
+			WithCache[string, string](cache),
+			WithWait[string, string](time.Millisecond),
+			WithTagFunc(func(_ string, result *Result[string]) []string {
+				return []string{"data:" + result.Data}
+			}),
+		)
+
+		ctx := context.Background()
+		thunk := loader.Load(ctx, "1")
+		<-started
+		loader.Replace(ctx, "1", "fresh")
+		close(release)
+		thunk()
+
+		cache.mu.Lock()
+		if r := cache.results["1"]; r == nil || r.Data != "fresh" {
+			t.Errorf("Expected the cache to be told about the replaced item, got %#v", r)
+		}
+		cache.mu.Unlock()
+		loader.ClearTag(ctx, "data:1")
+		if value, _ := loader.Load(ctx, "1")(); value != "fresh" {
+			t.Errorf("Expected the replaced item not to be tagged for the loaded result, got %q", value)
+		}
+	})
+
+	t.Run("LoadMany not found keys can be told apart from errors", func(t *testing.T) {
+		t.Parallel()
+		mapLoader, _ := MapLoader(0)
+		_, errs := mapLoader.LoadMany(context.Background(), []string{"1", "missing"})()
+		notFound, failed := SplitNotFound(errs)
+		if !reflect.DeepEqual(notFound, []bool{false, true}) {
+			t.Errorf("Expected only the missing key to be not found, got %v", notFound)
+		}
+		if failed != nil {
+			t.Errorf("Expected no other errors, got %v", failed)
+		}
+
+		_, failed = SplitNotFound([]error{nil, errors.New("error"), ErrNotFound})
+		if len(failed) != 3 || failed[0] != nil || failed[1] == nil || failed[2] != nil {
+			t.Errorf("Expected only the error at index 1, got %v", failed)
+		}
+	})
+
+	t.Run("prefetched keys are not passed to the batch function", func(t *testing.T) {
+		t.Parallel()
+		writtenBack := make(chan []string, 1)
+		identityLoader, loadCalls := IDLoader(0,
+			WithBatchPrefetch(func(_ context.Context, keys []string) []*Result[string] {
+				results := make([]*Result[string], len(keys))
+				for i, key := range keys {
+					if key == "external" {
+						results[i] = &Result[string]{Data: "from external cache"}
+					}
+				}
+				return results
+			}),
+			WithBatchWriteBack(func(_ context.Context, keys []string, results []*Result[string]) {
+				writtenBack <- keys
+			}),
+		)
+
+		ctx := context.Background()
+		future1 := identityLoader.Load(ctx, "1")
+		future2 := identityLoader.Load(ctx, "external")
+		if value, err := future1(); err != nil || value != "1" {
+			t.Errorf("Expected %q, got %q (%v)", "1", value, err)
+		}
+		if value, err := future2(); err != nil || value != "from external cache" {
+			t.Errorf("Expected prefetched value, got %q (%v)", value, err)
+		}
+
+		expected := [][]string{{"1"}}
+		if !reflect.DeepEqual(*loadCalls, expected) {
+			t.Errorf("Expected only missing keys to be loaded. Expected %#v, got %#v", expected, *loadCalls)
+		}
+		if keys := <-writtenBack; !reflect.DeepEqual(keys, []string{"1"}) {
+			t.Errorf("Expected loaded keys to be written back, got %#v", keys)
+		}
+	})
+
+	t.Run("stale items are returned at once and reloaded in the background", func(t *testing.T) {
+		t.Parallel()
+		var version int32
+		release := make(chan struct{})
+		tracer := &cacheHitTracer{}
+		cache := NewTTLCache(50*time.Millisecond, WithStaleTTL[string, string](time.Hour))
+		loader := NewBatchedLoader(func(_ context.Context, keys []string) []*Result[string] {
+			v := atomic.AddInt32(&version, 1)
+			if v > 1 {
+				<-release
+			}
+			results := make([]*Result[string], len(keys))
+			for i, key := range keys {
+				results[i] = &Result[string]{Data: fmt.Sprintf("%s@%d", key, v)}
+			}
+			return results
+		}, WithCache[string, string](cache), WithTracer[string, string](tracer))
+
+		ctx := context.Background()
+		if value, _ := loader.Load(ctx, "1")(); value != "1@1" {
+			t.Fatalf("Expected %q, got %q", "1@1", value)
+		}
+		if value, _ := loader.Load(ctx, "1")(); value != "1@1" {
+			t.Errorf("Expected the fresh cached value, got %q", value)
+		}
+		time.Sleep(60 * time.Millisecond)
+		if value, _ := loader.Load(ctx, "1")(); value != "1@1" {
+			t.Errorf("Expected the stale value while the key is reloaded, got %q", value)
+		}
+		if value, _ := loader.Load(ctx, "1")(); value != "1@1" {
+			t.Errorf("Expected the stale value while the key is reloaded, got %q", value)
+		}
+		close(release)
+
+		deadline := time.Now().Add(time.Second)
+		for {
+			if value, _ := loader.Load(ctx, "1")(); value == "1@2" {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("Expected the stale value to be replaced by the reloaded one")
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if v := atomic.LoadInt32(&version); v != 2 {
+			t.Errorf("Expected the stale key to be reloaded once, got %d batches", v)
+		}
+
+		hits := tracer.Hits()
+		if len(hits) < 4 || hits[0] || !hits[1] || !hits[2] {
+			t.Errorf("Expected a fresh hit then stale hits, got %v", hits)
+		}
+	})
+
+	t.Run("keys cleared while they are reloaded in the background stay cleared", func(t *testing.T) {
+		t.Parallel()
+		var version int32
+		release := make(chan struct{})
+		cache := NewTTLCache(50*time.Millisecond, WithStaleTTL[string, string](time.Hour))
+		loader := NewBatchedLoader(func(_ context.Context, keys []string) []*Result[string] {
+			v := atomic.AddInt32(&version, 1)
+			if v == 2 {
+				<-release
+			}
+			results := make([]*Result[string], len(keys))
+			for i, key := range keys {
+				results[i] = &Result[string]{Data: fmt.Sprintf("%s@%d", key, v)}
+			}
+			return results
+		}, WithCache[string, string](cache), WithWait[string, string](time.Millisecond))
+
+		ctx := context.Background()
+		loader.Load(ctx, "1")()
+		time.Sleep(60 * time.Millisecond)
+		if value, _ := loader.Load(ctx, "1")(); value != "1@1" {
+			t.Fatalf("Expected the stale value while the key is reloaded, got %q", value)
+		}
+		for atomic.LoadInt32(&version) < 2 {
+			time.Sleep(time.Millisecond)
+		}
+		loader.Clear(ctx, "1")
+		if value, _ := loader.Load(ctx, "1")(); value != "1@3" {
+			t.Errorf("Expected the cleared key to be loaded again, got %q", value)
+		}
+		close(release)
+
+		time.Sleep(10 * time.Millisecond)
+		if value, _ := loader.Load(ctx, "1")(); value != "1@3" {
+			t.Errorf("Expected the reload started before the clear to be dropped, got %q", value)
+		}
+	})
+
+	t.Run("failed reloads in the background keep the stale item", func(t *testing.T) {
+		t.Parallel()
+		var version int32
+		cache := NewTTLCache(50*time.Millisecond, WithStaleTTL[string, string](time.Hour))
+		loader := NewBatchedLoader(func(_ context.Context, keys []string) []*Result[string] {
+			v := atomic.AddInt32(&version, 1)
+			results := make([]*Result[string], len(keys))
+			for i, key := range keys {
+				if v > 1 {
+					results[i] = &Result[string]{Error: fmt.Errorf("failed to reload %s", key)}
+					continue
+				}
+				results[i] = &Result[string]{Data: fmt.Sprintf("%s@%d", key, v)}
+			}
+			return results
+		}, WithCache[string, string](cache), WithWait[string, string](time.Millisecond))
+
+		ctx := context.Background()
+		loader.Load(ctx, "1")()
+		time.Sleep(60 * time.Millisecond)
+		if value, _ := loader.Load(ctx, "1")(); value != "1@1" {
+			t.Fatalf("Expected the stale value while the key is reloaded, got %q", value)
+		}
+		for atomic.LoadInt32(&version) < 2 {
+			time.Sleep(time.Millisecond)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+		if value, err := loader.Load(ctx, "1")(); err != nil || value != "1@1" {
+			t.Errorf("Expected the failed reload to keep the stale value, got %q, %v", value, err)
+		}
+	})
+
+	t.Run("keys no longer found when reloaded in the background are cached as not found", func(t *testing.T) {
+		t.Parallel()
+		var version int32
+		cache := NewTTLCache(50*time.Millisecond, WithStaleTTL[string, string](time.Hour))
+		loader := NewBatchedLoader(func(_ context.Context, keys []string) []*Result[string] {
+			v := atomic.AddInt32(&version, 1)
+			results := make([]*Result[string], len(keys))
+			for i, key := range keys {
+				if v > 1 {
+					results[i] = NotFoundResult[string, string](key)
+					continue
+				}
+				results[i] = &Result[string]{Data: fmt.Sprintf("%s@%d", key, v)}
+			}
+			return results
+		}, WithCache[string, string](cache), WithWait[string, string](time.Millisecond))
+
+		ctx := context.Background()
+		loader.Load(ctx, "1")()
+		time.Sleep(60 * time.Millisecond)
+		if value, _ := loader.Load(ctx, "1")(); value != "1@1" {
+			t.Fatalf("Expected the stale value while the key is reloaded, got %q", value)
+		}
+		for atomic.LoadInt32(&version) < 2 {
+			time.Sleep(time.Millisecond)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+		if _, err := loader.Load(ctx, "1")(); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected the reload to replace the stale value with ErrNotFound, got %v", err)
+		}
+		if v := atomic.LoadInt32(&version); v != 2 {
+			t.Errorf("Expected the not found key to stay cached, got %d batches", v)
+		}
+	})
+
+	t.Run("stale items are returned at once when the queue is full", func(t *testing.T) {
+		t.Parallel()
+		var version int32
+		release := make(chan struct{})
+		cache := NewTTLCache(50*time.Millisecond, WithStaleTTL[string, string](time.Hour))
+		loader := NewBatchedLoader(func(_ context.Context, keys []string) []*Result[string] {
+			if atomic.AddInt32(&version, 1) > 1 {
+				<-release
+			}
+			results := make([]*Result[string], len(keys))
+			for i, key := range keys {
+				results[i] = &Result[string]{Data: key}
+			}
+			return results
+		},
+			WithCache[string, string](cache),
+			WithBatchCapacity[string, string](1),
+			WithMaxConcurrentBatches[string, string](1),
+			WithBatchQueue[string, string](1, BlockWhenFull),
+		)
+
+		ctx := context.Background()
+		loader.Load(ctx, "1")()
+		time.Sleep(60 * time.Millisecond)
+		loader.Load(ctx, "2")
+		loader.Load(ctx, "3")
+		waitForStats(t, loader, LoaderStats{Window: 16 * time.Millisecond, InFlightBatches: 1, QueuedBatches: 1})
+
+		loaded := make(chan Thunk[string])
+		go func() { loaded <- loader.Load(ctx, "1") }()
+		select {
+		case thunk := <-loaded:
+			if value, err := thunk(); err != nil || value != "1" {
+				t.Errorf("Expected the stale value, got %q (%v)", value, err)
+			}
+		case <-time.After(20 * time.Millisecond):
+			t.Error("Expected Load not to block on the reload of a stale item")
+		}
+		close(release)
+	})
+
+	t.Run("hot keys are reloaded before they expire", func(t *testing.T) {
+		t.Parallel()
+		var mu sync.Mutex
+		versions := make(map[string]int)
+		cache := NewTTLCache[string, string](200 * time.Millisecond)
+		loader := NewBatchedLoader(func(_ context.Context, keys []string) []*Result[string] {
+			mu.Lock()
+			defer mu.Unlock()
+			results := make([]*Result[string], len(keys))
+			for i, key := range keys {
+				versions[key]++
+				results[i] = &Result[string]{Data: fmt.Sprintf("%s@%d", key, versions[key])}
+			}
+			return results
+		}, WithCache[string, string](cache), WithRefreshAhead[string, string](0.5, 3))
+
+		ctx := context.Background()
+		loader.Load(ctx, "hot")()
+		loader.Load(ctx, "cold")()
+		loader.Load(ctx, "hot")()
+		loader.Load(ctx, "hot")()
+		loader.Load(ctx, "cold")()
+		time.Sleep(130 * time.Millisecond)
+
+		if value, _ := loader.Load(ctx, "hot")(); value != "hot@1" {
+			t.Errorf("Expected the cached value while the key is reloaded, got %q", value)
+		}
+		if value, _ := loader.Load(ctx, "cold")(); value != "cold@1" {
+			t.Errorf("Expected the cached value, got %q", value)
+		}
+
+		time.Sleep(30 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		if versions["hot"] != 2 {
+			t.Errorf("Expected the hot key to be reloaded once, got %d loads", versions["hot"])
+		}
+		if versions["cold"] != 1 {
+			t.Errorf("Expected the cold key not to be reloaded, got %d loads", versions["cold"])
+		}
+	})
+
+	t.Run("test ClearWhere", func(t *testing.T) {
+		t.Parallel()
+		identityLoader, loadCalls := IDLoader(0)
+		ctx := context.Background()
+		identityLoader.LoadMany(ctx, []string{"A1", "A2", "B1"})()
+		identityLoader.ClearWhere(ctx, func(key string) bool { return strings.HasPrefix(key, "A") })
+		identityLoader.LoadMany(ctx, []string{"A1", "A2", "B1"})()
+
+		if len(*loadCalls) != 2 || len((*loadCalls)[1]) != 2 {
+			t.Errorf("Expected only the matching keys to be loaded again, got %#v", *loadCalls)
+		}
+	})
+
+	t.Run("test ClearTag", func(t *testing.T) {
+		t.Parallel()
+		identityLoader, loadCalls := IDLoader(0,
+			WithTagFunc(func(key string, _ *Result[string]) []string {
+				return []string{"org:" + key[:1]}
+			}),
+		)
+		ctx := context.Background()
+		identityLoader.LoadMany(ctx, []string{"A1", "A2", "B1"})()
+		identityLoader.Prime(ctx, "A3", "A3")
+		identityLoader.ClearTag(ctx, "org:A")
+		identityLoader.LoadMany(ctx, []string{"A1", "A2", "A3", "B1"})()
+
+		if len(*loadCalls) != 2 || len((*loadCalls)[1]) != 3 {
+			t.Errorf("Expected only the tagged keys to be loaded again, got %#v", *loadCalls)
+		}
+	})
+
+	t.Run("prefetched keys are tagged", func(t *testing.T) {
+		t.Parallel()
+		var mu sync.Mutex
+		var prefetched []string
+		identityLoader, _ := IDLoader(0,
+			WithBatchPrefetch(func(_ context.Context, keys []string) []*Result[string] {
+				mu.Lock()
+				prefetched = append(prefetched, keys...)
+				mu.Unlock()
+				results := make([]*Result[string], len(keys))
+				for i, key := range keys {
+					results[i] = &Result[string]{Data: key}
+				}
+				return results
+			}),
+			WithTagFunc(func(key string, _ *Result[string]) []string {
+				return []string{"t"}
+			}),
+		)
+		ctx := context.Background()
+		identityLoader.Load(ctx, "1")()
+		identityLoader.ClearTag(ctx, "t")
+		identityLoader.Load(ctx, "1")()
+
+		mu.Lock()
+		defer mu.Unlock()
+		if expected := []string{"1", "1"}; !reflect.DeepEqual(prefetched, expected) {
+			t.Errorf("Expected the prefetched key to be cleared by its tag. Expected %#v, got %#v", expected, prefetched)
+		}
+	})
+
+	t.Run("idle scheduler dispatches once no key is added", func(t *testing.T) {
+		t.Parallel()
+		identityLoader, loadCalls := IDLoader(0,
+			WithWait[string, string](time.Hour),
+			WithScheduler[string, string](NewIdleScheduler(5*time.Millisecond)),
+		)
+		ctx := context.Background()
+		future1 := identityLoader.Load(ctx, "1")
+		future2 := identityLoader.Load(ctx, "2")
+		future1()
+		future2()
+
+		expected := [][]string{{"1", "2"}}
+		if !reflect.DeepEqual(*loadCalls, expected) {
+			t.Errorf("Expected a single batch. Expected %#v, got %#v", expected, *loadCalls)
+		}
+	})
+
+	t.Run("max latency scheduler dispatches full batches", func(t *testing.T) {
+		t.Parallel()
+		identityLoader, loadCalls := IDLoader(0,
+			WithScheduler[string, string](NewMaxLatencyScheduler(20*time.Millisecond, 2)),
+		)
+		ctx := context.Background()
+		future1 := identityLoader.Load(ctx, "1")
+		future2 := identityLoader.Load(ctx, "2")
+		future3 := identityLoader.Load(ctx, "3")
+		future1()
+		future2()
+		future3()
+
+		expected := [][]string{{"1", "2"}, {"3"}}
+		if !reflect.DeepEqual(*loadCalls, expected) {
+			t.Errorf("Expected batches of at most 2 keys. Expected %#v, got %#v", expected, *loadCalls)
+		}
+	})
+
+	t.Run("manual scheduler dispatches when told to", func(t *testing.T) {
+		t.Parallel()
+		scheduler := NewManualScheduler()
+		identityLoader, loadCalls := IDLoader(0, WithScheduler[string, string](scheduler))
+		ctx := context.Background()
+		future1 := identityLoader.Load(ctx, "1")
+		future2 := identityLoader.Load(ctx, "2")
+
+		time.Sleep(30 * time.Millisecond)
+		if calls := len(*loadCalls); calls != 0 {
+			t.Fatalf("Expected no batch before dispatch, got %d", calls)
+		}
+		scheduler.Dispatch()
+		future1()
+		future2()
+
+		expected := [][]string{{"1", "2"}}
+		if !reflect.DeepEqual(*loadCalls, expected) {
+			t.Errorf("Expected a single batch. Expected %#v, got %#v", expected, *loadCalls)
+		}
+	})
+
+	t.Run("manual dispatch dispatches when Dispatch is called", func(t *testing.T) {
+		t.Parallel()
+		identityLoader, loadCalls := IDLoader(0, WithManualDispatch[string, string]())
+		ctx := context.Background()
+		identityLoader.Load(ctx, "1")
+		identityLoader.Load(ctx, "2")
+
+		time.Sleep(30 * time.Millisecond)
+		if calls := len(*loadCalls); calls != 0 {
+			t.Fatalf("Expected no batch before dispatch, got %d", calls)
+		}
+		identityLoader.Dispatch(ctx)
+		if value, err := identityLoader.Load(ctx, "1")(); err != nil || value != "1" {
+			t.Errorf("Expected %q, got %q (%v)", "1", value, err)
+		}
+
+		expected := [][]string{{"1", "2"}}
+		if !reflect.DeepEqual(*loadCalls, expected) {
+			t.Errorf("Expected a single batch. Expected %#v, got %#v", expected, *loadCalls)
+		}
+	})
+
+	t.Run("manual dispatch dispatches when a thunk is awaited", func(t *testing.T) {
+		t.Parallel()
+		identityLoader, loadCalls := IDLoader(0, WithManualDispatch[string, string]())
+		ctx := context.Background()
+		future1 := identityLoader.Load(ctx, "1")
+		futureMany := identityLoader.LoadMany(ctx, []string{"2", "3"})
+		cached := identityLoader.Load(ctx, "1")
+
+		if value, err := cached(); err != nil || value != "1" {
+			t.Errorf("Expected %q, got %q (%v)", "1", value, err)
+		}
+		future1()
+		if values, errs := futureMany(); errs != nil || !reflect.DeepEqual(values, []string{"2", "3"}) {
+			t.Errorf("Expected %#v, got %#v (%v)", []string{"2", "3"}, values, errs)
+		}
+		identityLoader.LoadMany(ctx, []string{"4", "5"})()
+
+		expected := [][]string{{"1", "2", "3"}, {"4", "5"}}
+		if !reflect.DeepEqual(*loadCalls, expected) {
+			t.Errorf("Expected the keys loaded before an await to be batched. Expected %#v, got %#v", expected, *loadCalls)
+		}
+	})
+
+	t.Run("dispatch on await doesn't wait for the batch window", func(t *testing.T) {
+		t.Parallel()
+		identityLoader, loadCalls := IDLoader(0,
+			WithScheduler[string, string](NewFixedWindowScheduler(time.Hour)),
+			WithDispatchOnAwait[string, string](),
+		)
+		ctx := context.Background()
+		future1 := identityLoader.Load(ctx, "1")
+		future2 := identityLoader.Load(ctx, "2")
+		future2()
+		future1()
+
+		expected := [][]string{{"1", "2"}}
+		if !reflect.DeepEqual(*loadCalls, expected) {
+			t.Errorf("Expected the keys loaded before the await to be batched. Expected %#v, got %#v", expected, *loadCalls)
+		}
+	})
+
+	t.Run("batches beyond the maximum concurrency wait in a queue", func(t *testing.T) {
+		t.Parallel()
+		release := make(chan struct{})
+		loader, loadCalls := BlockingLoader(release,
+			WithBatchCapacity[string, string](1),
+			WithMaxConcurrentBatches[string, string](1),
+		)
+		ctx := context.Background()
+		future1 := loader.Load(ctx, "1")
+		waitForStats(t, loader, LoaderStats{Window: 16 * time.Millisecond, InFlightBatches: 1})
+		future2 := loader.Load(ctx, "2")
+		waitForStats(t, loader, LoaderStats{Window: 16 * time.Millisecond, InFlightBatches: 1, QueuedBatches: 1})
+		future3 := loader.Load(ctx, "3")
+		waitForStats(t, loader, LoaderStats{Window: 16 * time.Millisecond, InFlightBatches: 1, QueuedBatches: 2})
+		close(release)
+		future1()
+		future2()
+		future3()
+
+		expected := [][]string{{"1"}, {"2"}, {"3"}}
+		if !reflect.DeepEqual(*loadCalls, expected) {
+			t.Errorf("Expected batches to run one after the other. Expected %#v, got %#v", expected, *loadCalls)
+		}
+	})
+
+	t.Run("batches fail fast when the queue is full", func(t *testing.T) {
+		t.Parallel()
+		release := make(chan struct{})
+		loader, _ := BlockingLoader(release,
+			WithBatchCapacity[string, string](1),
+			WithMaxConcurrentBatches[string, string](1),
+			WithBatchQueue[string, string](1, FailWhenFull),
+		)
+		ctx := context.Background()
+		loader.Load(ctx, "1")
+		loader.Load(ctx, "2")
+		waitForStats(t, loader, LoaderStats{Window: 16 * time.Millisecond, InFlightBatches: 1, QueuedBatches: 1})
+
+		var queueFull *BatchQueueFullError
+		if _, err := loader.Load(ctx, "3")(); !errors.As(err, &queueFull) || queueFull.Queued != 1 {
+			t.Errorf("Expected a *BatchQueueFullError, got %v", err)
+		}
+		close(release)
+		waitForStats(t, loader, LoaderStats{Window: 16 * time.Millisecond})
+		if value, err := loader.Load(ctx, "3")(); err != nil || value != "3" {
+			t.Errorf("Expected rejected key not to be cached, got %q (%v)", value, err)
+		}
+	})
+
+	t.Run("batches are merged when the queue is full", func(t *testing.T) {
+		t.Parallel()
+		release := make(chan struct{})
+		loader, loadCalls := BlockingLoader(release,
+			WithBatchCapacity[string, string](2),
+			WithMaxConcurrentBatches[string, string](1),
+			WithBatchQueue[string, string](1, MergeWhenFull),
+		)
+		ctx := context.Background()
+		future1 := loader.Load(ctx, "1")
+		waitForStats(t, loader, LoaderStats{Window: 16 * time.Millisecond, InFlightBatches: 1})
+		future2 := loader.Load(ctx, "2")
+		waitForStats(t, loader, LoaderStats{Window: 16 * time.Millisecond, InFlightBatches: 1, QueuedBatches: 1})
+		future3 := loader.Load(ctx, "3")
+		time.Sleep(30 * time.Millisecond)
+		close(release)
+		future1()
+		future2()
+		future3()
+
+		expected := [][]string{{"1"}, {"2", "3"}}
+		if !reflect.DeepEqual(*loadCalls, expected) {
+			t.Errorf("Expected the last batch to be merged. Expected %#v, got %#v", expected, *loadCalls)
+		}
+	})
+
+	t.Run("merged batches respect the batch capacity and don't repeat keys", func(t *testing.T) {
+		t.Parallel()
+		release := make(chan struct{})
+		loader, loadCalls := BlockingLoader(release,
+			WithCache[string, string](&NoCache[string, string]{}),
+			WithBatchCapacity[string, string](2),
+			WithMaxConcurrentBatches[string, string](1),
+			WithBatchQueue[string, string](1, MergeWhenFull),
+		)
+		ctx := context.Background()
+		futures := []Thunk[string]{loader.Load(ctx, "1")}
+		waitForStats(t, loader, LoaderStats{Window: 16 * time.Millisecond, InFlightBatches: 1})
+		futures = append(futures, loader.Load(ctx, "2"))
+		waitForStats(t, loader, LoaderStats{Window: 16 * time.Millisecond, InFlightBatches: 1, QueuedBatches: 1})
+		futures = append(futures, loader.Load(ctx, "2"), loader.Load(ctx, "3"))
+		time.Sleep(30 * time.Millisecond)
+		futures = append(futures, loader.Load(ctx, "4"))
+		waitForStats(t, loader, LoaderStats{Window: 16 * time.Millisecond, InFlightBatches: 1, QueuedBatches: 2})
+		close(release)
+		for i, expected := range []string{"1", "2", "2", "3", "4"} {
+			if value, err := futures[i](); err != nil || value != expected {
+				t.Errorf("Expected %q, got %q (%v)", expected, value, err)
+			}
+		}
+
+		expected := [][]string{{"1"}, {"2", "3"}, {"4"}}
+		if !reflect.DeepEqual(*loadCalls, expected) {
+			t.Errorf("Expected the keys beyond the batch capacity to wait as a batch of their own. Expected %#v, got %#v", expected, *loadCalls)
+		}
+	})
+
+	t.Run("calls to Load block when the queue is full", func(t *testing.T) {
+		t.Parallel()
+		release := make(chan struct{})
+		loader, _ := BlockingLoader(release,
+			WithBatchCapacity[string, string](1),
+			WithMaxConcurrentBatches[string, string](1),
+			WithBatchQueue[string, string](1, BlockWhenFull),
+		)
+		ctx := context.Background()
+		loader.Load(ctx, "1")
+		loader.Load(ctx, "2")
+		waitForStats(t, loader, LoaderStats{Window: 16 * time.Millisecond, InFlightBatches: 1, QueuedBatches: 1})
+
+		loaded := make(chan Thunk[string])
+		go func() { loaded <- loader.Load(ctx, "3") }()
+		select {
+		case <-loaded:
+			t.Fatal("Expected Load to block while the queue is full")
+		case <-time.After(20 * time.Millisecond):
+		}
+		close(release)
+		if value, err := (<-loaded)(); err != nil || value != "3" {
+			t.Errorf("Expected %q, got %q (%v)", "3", value, err)
+		}
+	})
+
+	t.Run("batches dispatched once the queue is full wait to enter it", func(t *testing.T) {
+		t.Parallel()
+		release := make(chan struct{})
+		loader, _ := BlockingLoader(release,
+			WithBatchCapacity[string, string](1),
+			WithMaxConcurrentBatches[string, string](1),
+			WithBatchQueue[string, string](1, BlockWhenFull),
+		)
+		ctx := context.Background()
+		future1 := loader.Load(ctx, "1")
+		waitForStats(t, loader, LoaderStats{Window: 16 * time.Millisecond, InFlightBatches: 1})
+		// both calls get past the empty queue before their batch is dispatched
+		future2 := loader.Load(ctx, "2")
+		future3 := loader.Load(ctx, "3")
+		waitForStats(t, loader, LoaderStats{Window: 16 * time.Millisecond, InFlightBatches: 1, QueuedBatches: 1})
+		time.Sleep(20 * time.Millisecond)
+		if stats := loader.Stats(); stats.QueuedBatches != 1 {
+			t.Errorf("Expected the queue to hold at most 1 batch, got %d", stats.QueuedBatches)
+		}
+		close(release)
+		future1()
+		future2()
+		future3()
+	})
+
+	t.Run("batches which time out stay in flight until the batch function returns", func(t *testing.T) {
+		t.Parallel()
+		release := make(chan struct{})
+		loader, loadCalls := BlockingLoader(release,
+			WithBatchCapacity[string, string](1),
+			WithMaxConcurrentBatches[string, string](1),
+			WithBatchTimeout[string, string](10*time.Millisecond),
+		)
+		ctx := context.Background()
+		var timeout *BatchTimeoutError
+		if _, err := loader.Load(ctx, "1")(); !errors.As(err, &timeout) {
+			t.Fatalf("Expected a *BatchTimeoutError, got %v", err)
+		}
+		future2 := loader.Load(ctx, "2")
+		waitForStats(t, loader, LoaderStats{Window: 16 * time.Millisecond, InFlightBatches: 1, QueuedBatches: 1})
+		close(release)
+		if value, err := future2(); err != nil || value != "2" {
+			t.Errorf("Expected %q, got %q (%v)", "2", value, err)
+		}
+
+		expected := [][]string{{"1"}, {"2"}}
+		if !reflect.DeepEqual(*loadCalls, expected) {
+			t.Errorf("Expected the batch to wait for the timed out batch function. Expected %#v, got %#v", expected, *loadCalls)
+		}
+	})
+
 	t.Run("no cache does not cache anything", func(t *testing.T) {
 		t.Parallel()
 		identityLoader, loadCalls := NoCacheLoader(0)
@@ -458,8 +1591,121 @@ func TestLoader(t *testing.T) {
 	})
 }
 
+func TestAdaptiveScheduler(t *testing.T) {
+	t.Run("window follows the traffic", func(t *testing.T) {
+		t.Parallel()
+		scheduler := NewAdaptiveScheduler(time.Millisecond, 50*time.Millisecond)
+		if window := scheduler.Window(); window != time.Millisecond {
+			t.Errorf("Expected the minimum window at first, got %v", window)
+		}
+
+		now := time.Now()
+		scheduler.BatchDone(10, 20*time.Millisecond)
+		for i := 0; i < 10; i++ {
+			scheduler.arrived(now.Add(time.Duration(i) * time.Millisecond))
+		}
+		if window := scheduler.Window(); window != 10*time.Millisecond {
+			t.Errorf("Expected half the batch latency when keys arrive often, got %v", window)
+		}
+
+		for i := 0; i < 20; i++ {
+			scheduler.BatchDone(10, time.Second)
+		}
+		if window := scheduler.Window(); window != 50*time.Millisecond {
+			t.Errorf("Expected the window to be bounded by the maximum, got %v", window)
+		}
+
+		now = now.Add(time.Minute)
+		for i := 0; i < 20; i++ {
+			scheduler.arrived(now.Add(time.Duration(i) * time.Minute))
+		}
+		if window := scheduler.Window(); window != time.Millisecond {
+			t.Errorf("Expected the minimum window when keys are rare, got %v", window)
+		}
+	})
+
+	t.Run("loader reports the window of its scheduler", func(t *testing.T) {
+		t.Parallel()
+		identityLoader, _ := IDLoader(0)
+		if window := identityLoader.Stats().Window; window != 16*time.Millisecond {
+			t.Errorf("Expected the default window, got %v", window)
+		}
+
+		scheduler := NewAdaptiveScheduler(time.Millisecond, 50*time.Millisecond)
+		adaptiveLoader, _ := IDLoader(0, WithScheduler[string, string](scheduler))
+		adaptiveLoader.Load(context.Background(), "1")()
+		if window, expected := adaptiveLoader.Stats().Window, scheduler.Window(); window != expected {
+			t.Errorf("Expected the window of the adaptive scheduler %v, got %v", expected, window)
+		}
+		if scheduler.latency == 0 {
+			t.Error("Expected the scheduler to be told the latency of the batch function")
+		}
+	})
+}
+
+func TestInvalidator(t *testing.T) {
+	t.Run("clears are propagated to the loaders sharing a channel", func(t *testing.T) {
+		t.Parallel()
+		invalidator := NewMemoryInvalidator[string]()
+		loader1, _ := InvalidatedLoader(invalidator, "users")
+		loader2, loadCalls := InvalidatedLoader(invalidator, "users")
+		other, otherCalls := InvalidatedLoader(invalidator, "orgs")
+		ctx := context.Background()
+		keys := []string{"A1", "A2", "B1"}
+		loader2.LoadMany(ctx, keys)()
+		other.LoadMany(ctx, keys)()
+
+		loader1.Clear(ctx, "A1")
+		loader1.ClearTag(ctx, "org:B")
+		loader2.LoadMany(ctx, keys)()
+		if len(*loadCalls) != 2 || len((*loadCalls)[1]) != 2 {
+			t.Errorf("Expected the cleared keys to be loaded again, got %#v", *loadCalls)
+		}
+
+		loader1.ClearAll()
+		loader2.LoadMany(ctx, keys)()
+		if len(*loadCalls) != 3 || len((*loadCalls)[2]) != 3 {
+			t.Errorf("Expected every key to be loaded again, got %#v", *loadCalls)
+		}
+
+		loader2.Close()
+		loader1.ClearAll()
+		loader2.LoadMany(ctx, keys)()
+		if len(*loadCalls) != 3 {
+			t.Errorf("Expected a closed loader to keep its cache, got %#v", *loadCalls)
+		}
+
+		other.LoadMany(ctx, keys)()
+		if len(*otherCalls) != 1 {
+			t.Errorf("Expected loaders on other channels to keep their cache, got %#v", *otherCalls)
+		}
+	})
+
+	t.Run("invalidations are published over a message bus", func(t *testing.T) {
+		t.Parallel()
+		bus := &memoryBus{handlers: make(map[string][]func(context.Context, []byte))}
+		invalidator := NewBusInvalidator[string](bus, JSONCodec[Invalidation[string]]{})
+		loader1, _ := InvalidatedLoader(invalidator, "users")
+		loader2, loadCalls := InvalidatedLoader(invalidator, "users")
+		ctx := context.Background()
+		loader2.LoadMany(ctx, []string{"A1", "A2"})()
+
+		loader1.Clear(ctx, "A1")
+		loader2.LoadMany(ctx, []string{"A1", "A2"})()
+		if len(*loadCalls) != 2 || !reflect.DeepEqual((*loadCalls)[1], []string{"A1"}) {
+			t.Errorf("Expected the cleared key to be loaded again, got %#v", *loadCalls)
+		}
+
+		bus.Publish(ctx, "users", []byte("garbage"))
+		loader2.LoadMany(ctx, []string{"A1", "A2"})()
+		if len(*loadCalls) != 3 || len((*loadCalls)[2]) != 2 {
+			t.Errorf("Expected a message which can't be decoded to clear every key, got %#v", *loadCalls)
+		}
+	})
+}
+
 // test helpers
-func IDLoader(max int) (*Loader[string, string], *[][]string) {
+func IDLoader(max int, opts ...Option[string, string]) (*Loader[string, string], *[][]string) {
 	var mu sync.Mutex
 	var loadCalls [][]string
 	identityLoader := NewBatchedLoader(func(_ context.Context, keys []string) []*Result[string] {
@@ -471,7 +1717,7 @@ func IDLoader(max int) (*Loader[string, string], *[][]string) {
 			results = append(results, &Result[string]{key, nil})
 		}
 		return results
-	}, WithBatchCapacity[string, string](max))
+	}, append([]Option[string, string]{WithBatchCapacity[string, string](max)}, opts...)...)
 	return identityLoader, &loadCalls
 }
 
@@ -491,7 +1737,7 @@ func BatchOnlyLoader(max int) (*Loader[string, string], *[][]string) {
 	return identityLoader, &loadCalls
 }
 
-func ErrorLoader(max int) (*Loader[string, string], *[][]string) {
+func ErrorLoader(max int, opts ...Option[string, string]) (*Loader[string, string], *[][]string) {
 	var mu sync.Mutex
 	var loadCalls [][]string
 	identityLoader := NewBatchedLoader(func(_ context.Context, keys []string) []*Result[string] {
@@ -503,7 +1749,7 @@ func ErrorLoader(max int) (*Loader[string, string], *[][]string) {
 			results = append(results, &Result[string]{key, fmt.Errorf("this is a test error")})
 		}
 		return results
-	}, WithBatchCapacity[string, string](max))
+	}, append([]Option[string, string]{WithBatchCapacity[string, string](max)}, opts...)...)
 	return identityLoader, &loadCalls
 }
 
@@ -567,6 +1813,156 @@ func NoCacheLoader(max int) (*Loader[string, string], *[][]string) {
 	return identityLoader, &loadCalls
 }
 
+func MapLoader(max int, opts ...Option[string, string]) (*Loader[string, string], *[][]string) {
+	var mu sync.Mutex
+	var loadCalls [][]string
+	mapLoader := NewMapBatchedLoader(func(_ context.Context, keys []string) (map[string]string, error) {
+		results := make(map[string]string, len(keys))
+		mu.Lock()
+		loadCalls = append(loadCalls, keys)
+		mu.Unlock()
+		// resolve in reverse order, skipping missing keys
+		for i := len(keys) - 1; i >= 0; i-- {
+			if keys[i] != "missing" {
+				results[keys[i]] = keys[i]
+			}
+		}
+		return results, nil
+	}, append([]Option[string, string]{WithBatchCapacity[string, string](max)}, opts...)...)
+	return mapLoader, &loadCalls
+}
+
+// BlockingLoader doesn't return results until release is closed.
+func BlockingLoader(release chan struct{}, opts ...Option[string, string]) (*Loader[string, string], *[][]string) {
+	var mu sync.Mutex
+	var loadCalls [][]string
+	loader := NewBatchedLoader(func(_ context.Context, keys []string) []*Result[string] {
+		var results []*Result[string]
+		mu.Lock()
+		loadCalls = append(loadCalls, keys)
+		mu.Unlock()
+		<-release
+		for _, key := range keys {
+			results = append(results, &Result[string]{key, nil})
+		}
+		return results
+	}, opts...)
+	return loader, &loadCalls
+}
+
+// InvalidatedLoader is an identity loader whose items are tagged with the first letter of their key.
+func InvalidatedLoader(invalidator Invalidator[string], channel string) (*Loader[string, string], *[][]string) {
+	var mu sync.Mutex
+	var loadCalls [][]string
+	loader := NewBatchedLoader(func(_ context.Context, keys []string) []*Result[string] {
+		var results []*Result[string]
+		mu.Lock()
+		loadCalls = append(loadCalls, keys)
+		mu.Unlock()
+		for _, key := range keys {
+			results = append(results, &Result[string]{key, nil})
+		}
+		return results
+	},
+		WithInvalidator[string, string](invalidator, channel),
+		WithTagFunc(func(key string, _ *Result[string]) []string {
+			return []string{"org:" + key[:1]}
+		}),
+	)
+	return loader, &loadCalls
+}
+
+// memoryBus delivers messages to the handlers subscribed to their channel.
+type memoryBus struct {
+	mu       sync.Mutex
+	handlers map[string][]func(context.Context, []byte)
+}
+
+func (b *memoryBus) Publish(ctx context.Context, channel string, message []byte) error {
+	b.mu.Lock()
+	handlers := b.handlers[channel]
+	b.mu.Unlock()
+	for _, handler := range handlers {
+		handler(ctx, message)
+	}
+	return nil
+}
+
+func (b *memoryBus) Subscribe(channel string, handler func(context.Context, []byte)) (func(), error) {
+	b.mu.Lock()
+	b.handlers[channel] = append(b.handlers[channel], handler)
+	b.mu.Unlock()
+	return func() {}, nil
+}
+
+// waitForStats waits until the stats of loader are expected.
+func waitForStats(t *testing.T, loader *Loader[string, string], expected LoaderStats) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		stats := loader.Stats()
+		if stats == expected {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected stats %+v, got %+v", expected, stats)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// resultRecordingCache records the results the loader resolves.
+type resultRecordingCache struct {
+	*InMemoryCache[string, string]
+	mu      sync.Mutex
+	results map[string]*Result[string]
+}
+
+func (c *resultRecordingCache) Resolve(_ context.Context, key string, result *Result[string]) {
+	c.mu.Lock()
+	c.results[key] = result
+	c.mu.Unlock()
+}
+
+// cacheHitTracer records whether each cache hit was stale.
+type cacheHitTracer struct {
+	NoopTracer[string, string]
+	mu   sync.Mutex
+	hits []bool
+}
+
+func (t *cacheHitTracer) TraceCacheHit(_ context.Context, _ string, stale bool) {
+	t.mu.Lock()
+	t.hits = append(t.hits, stale)
+	t.mu.Unlock()
+}
+
+func (t *cacheHitTracer) Hits() []bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]bool(nil), t.hits...)
+}
+
+// attemptTracer records the attempt of every traced batch.
+type attemptTracer struct {
+	NoopTracer[string, string]
+	mu       sync.Mutex
+	attempts []int
+}
+
+func (t *attemptTracer) TraceBatch(ctx context.Context, keys []string) (context.Context, TraceBatchFinishFunc[string]) {
+	t.mu.Lock()
+	t.attempts = append(t.attempts, BatchAttempt(ctx))
+	t.mu.Unlock()
+	return ctx, func([]*Result[string]) {}
+}
+
+func (t *attemptTracer) Attempts() []int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]int(nil), t.attempts...)
+}
+
 // FaultyLoader gives len(keys)-1 results.
 func FaultyLoader() (*Loader[string, string], *[][]string) {
 	var mu sync.Mutex
@@ -616,6 +2012,33 @@ func BenchmarkLoader(b *testing.B) {
 	log.Printf("avg: %f", a.Avg())
 }
 
+func BenchmarkLoaderParallel(b *testing.B) {
+	b.Run("InMemoryCache", func(b *testing.B) {
+		benchmarkLoaderParallel(b, NewBatchedLoader(batchIdentity), _ctx)
+	})
+	b.Run("ShardedCache", func(b *testing.B) {
+		benchmarkLoaderParallel(b, NewBatchedLoader(batchIdentity, WithCache[string, string](NewShardedCache[string, string](0))), _ctx)
+	})
+	// the thunks of callers whose context can be cancelled wait on the pending result of their key
+	b.Run("ShardedCache/CancellableContext", func(b *testing.B) {
+		ctx, cancel := context.WithCancel(_ctx)
+		defer cancel()
+		benchmarkLoaderParallel(b, NewBatchedLoader(batchIdentity, WithCache[string, string](NewShardedCache[string, string](0))), ctx)
+	})
+}
+
+// benchmarkLoaderParallel loads keys from many goroutines, half of them already cached.
+func benchmarkLoaderParallel(b *testing.B, loader *Loader[string, string], ctx context.Context) {
+	var n int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := atomic.AddInt64(&n, 1)
+			loader.Load(ctx, strconv.FormatInt(i/2, 10))
+		}
+	})
+}
+
 type Avg struct {
 	total  float64
 	length float64
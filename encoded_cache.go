@@ -0,0 +1,415 @@
+package dataloader
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RemoteCacheOption allows for configuration of the caches backed by a RemoteStore
+// (EncodedCache and TieredCache).
+type RemoteCacheOption[Key comparable, Value any] func(*remoteCacheOptions[Key, Value])
+
+type remoteCacheOptions[Key comparable, Value any] struct {
+	local    AtomicCache[Key, Value]
+	ttl      time.Duration
+	errorTTL time.Duration
+	keyFunc  func(Key) string
+	onError  func(error)
+	// how many of the keys it wrote to the store an EncodedCache remembers
+	storedKeys int
+}
+
+// WithLocalCache sets the local tier of a TieredCache. Default is an InMemoryCache.
+func WithLocalCache[Key comparable, Value any](c AtomicCache[Key, Value]) RemoteCacheOption[Key, Value] {
+	return func(o *remoteCacheOptions[Key, Value]) {
+		o.local = c
+	}
+}
+
+// WithRemoteTTL sets the time to live of the results written to the remote store. Default is 0 (never expire).
+func WithRemoteTTL[Key comparable, Value any](ttl time.Duration) RemoteCacheOption[Key, Value] {
+	return func(o *remoteCacheOptions[Key, Value]) {
+		o.ttl = ttl
+	}
+}
+
+// WithRemoteErrors writes the errors other than ErrNotFound to the remote store too, expiring after ttl
+// (or the time to live set by WithRemoteTTL if it is shorter), so that the other processes don't call the
+// batch function for a failing key meanwhile. By default only values and not found results are written,
+// so that a transient failure isn't served to every process. A ttl of 0 doesn't write errors.
+func WithRemoteErrors[Key comparable, Value any](ttl time.Duration) RemoteCacheOption[Key, Value] {
+	return func(o *remoteCacheOptions[Key, Value]) {
+		o.errorTTL = ttl
+	}
+}
+
+// WithRemoteKey sets how keys are turned into remote store keys, e.g. to add a prefix per loader.
+// Default is fmt.Sprint.
+func WithRemoteKey[Key comparable, Value any](fn func(Key) string) RemoteCacheOption[Key, Value] {
+	return func(o *remoteCacheOptions[Key, Value]) {
+		o.keyFunc = fn
+	}
+}
+
+// WithRemoteErrorHandler sets a function called with the errors of the remote store, which are otherwise
+// ignored: a failing remote store only causes keys to be fetched by the batch function.
+func WithRemoteErrorHandler[Key comparable, Value any](fn func(error)) RemoteCacheOption[Key, Value] {
+	return func(o *remoteCacheOptions[Key, Value]) {
+		o.onError = fn
+	}
+}
+
+// WithStoredKeys sets how many of the keys it wrote to the store an EncodedCache remembers, so that they can
+// be deleted by tag or by predicate (see EncodedCache). Default is 10000.
+func WithStoredKeys[Key comparable, Value any](capacity int) RemoteCacheOption[Key, Value] {
+	return func(o *remoteCacheOptions[Key, Value]) {
+		o.storedKeys = capacity
+	}
+}
+
+func newRemoteCacheOptions[Key comparable, Value any](opts []RemoteCacheOption[Key, Value]) remoteCacheOptions[Key, Value] {
+	o := remoteCacheOptions[Key, Value]{
+		keyFunc:    func(key Key) string { return fmt.Sprint(key) },
+		onError:    func(error) {},
+		storedKeys: 10000,
+	}
+	for _, apply := range opts {
+		apply(&o)
+	}
+	return o
+}
+
+// EncodedCache is an implementation of the Cache interface which stores the results keys resolved to
+// serialized in a RemoteStore: their value encoded by a Codec, whether they were not found, the message
+// of their error, and their expiry.
+//
+// The store isn't looked up by Get: the keys of each batch are read from it at once, and decoded back into
+// results, and only the keys it misses are passed on to the batch function (see PrefetchCache).
+// Values and not found results are stored, unless the error caching policy of the loader removes them
+// (see WithErrorCachePolicy). Other errors are only stored for a limited time (see WithRemoteErrors).
+// The thunks of the keys which haven't resolved yet are kept in memory.
+//
+// Keys can only be deleted by tag or by predicate (see TagCache and PredicateCache) if they were set in
+// this process, which remembers them until they are deleted, expire from the store, or are among the oldest
+// beyond the capacity set by WithStoredKeys: the keys stored by other processes sharing the store aren't known.
+type EncodedCache[Key comparable, Value any] struct {
+	store   RemoteStore
+	results resultCodec[Key, Value]
+	remoteCacheOptions[Key, Value]
+
+	mu      sync.Mutex
+	pending map[Key]Thunk[Value]
+	// pending keys found in the store by Prefetch, which aren't written back to it once they resolve
+	prefetched map[Key]struct{}
+	stored     *storedKeys[Key]
+	tags       tagIndex[Key]
+}
+
+// NewEncodedCache constructs a new EncodedCache storing results in store, serializing values with codec.
+func NewEncodedCache[Key comparable, Value any](store RemoteStore, codec Codec[Value], opts ...RemoteCacheOption[Key, Value]) *EncodedCache[Key, Value] {
+	c := &EncodedCache[Key, Value]{
+		store:              store,
+		results:            resultCodec[Key, Value]{codec: codec},
+		remoteCacheOptions: newRemoteCacheOptions(opts),
+		pending:            make(map[Key]Thunk[Value]),
+		prefetched:         make(map[Key]struct{}),
+	}
+	c.stored = newStoredKeys[Key](c.storedKeys)
+	return c
+}
+
+// Get gets the value at `key` if it is pending, returns value (or nil) and bool indicating of value was found.
+// The store is only read by Prefetch.
+func (c *EncodedCache[Key, Value]) Get(_ context.Context, key Key) (Thunk[Value], bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	thunk, found := c.pending[key]
+	return thunk, found
+}
+
+// Set keeps the `value` at `key` in memory until Resolve or Delete is called for `key`
+func (c *EncodedCache[Key, Value]) Set(_ context.Context, key Key, value Thunk[Value]) {
+	c.mu.Lock()
+	c.pending[key] = value
+	delete(c.prefetched, key)
+	c.tags.remove(key)
+	c.mu.Unlock()
+}
+
+// GetOrSet returns the value at `key` and true if it is pending, otherwise it keeps `value` at `key`
+// in memory until it resolves and returns it and false
+func (c *EncodedCache[Key, Value]) GetOrSet(_ context.Context, key Key, value Thunk[Value]) (Thunk[Value], bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if thunk, found := c.pending[key]; found {
+		return thunk, true
+	}
+	c.pending[key] = value
+	return value, false
+}
+
+// Prefetch looks the keys up in the store at once, returning the results of the keys it found
+// and nil for the others.
+func (c *EncodedCache[Key, Value]) Prefetch(ctx context.Context, keys []Key) []*Result[Value] {
+	results := c.getMany(ctx, keys)
+
+	c.mu.Lock()
+	for i, result := range results {
+		if _, pending := c.pending[keys[i]]; result != nil && pending {
+			c.prefetched[keys[i]] = struct{}{}
+		}
+	}
+	c.mu.Unlock()
+	return results
+}
+
+// Resolve stores the result `key` resolved to, unless it is an error which isn't stored (see WithRemoteErrors),
+// it was read from the store by Prefetch, or `key` was deleted, or the cache cleared, since it was set
+func (c *EncodedCache[Key, Value]) Resolve(ctx context.Context, key Key, result *Result[Value]) {
+	c.mu.Lock()
+	_, pending := c.pending[key]
+	_, prefetched := c.prefetched[key]
+	if prefetched {
+		delete(c.pending, key)
+		delete(c.prefetched, key)
+	}
+	c.mu.Unlock()
+	if !pending || prefetched {
+		return
+	}
+
+	expires, stored := c.write(ctx, key, result)
+
+	c.mu.Lock()
+	delete(c.pending, key)
+	if stored {
+		for _, forgotten := range c.stored.add(key, expires) {
+			c.tags.remove(forgotten)
+		}
+	}
+	c.mu.Unlock()
+}
+
+// write writes the result `key` resolved to to the store, unless it is an error which isn't stored,
+// and reports whether it did and when the result expires (zero if it never does)
+func (c *EncodedCache[Key, Value]) write(ctx context.Context, key Key, result *Result[Value]) (time.Time, bool) {
+	ttl := c.ttl
+	if result.Error != nil && !result.NotFound() {
+		if c.errorTTL <= 0 {
+			return time.Time{}, false
+		}
+		if ttl <= 0 || c.errorTTL < ttl {
+			ttl = c.errorTTL
+		}
+	}
+
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+
+	data, err := c.results.encode(result, expires)
+	if err == nil {
+		err = c.store.Set(ctx, c.keyFunc(key), data, ttl)
+	}
+	if err != nil {
+		c.onError(err)
+		return time.Time{}, false
+	}
+	return expires, true
+}
+
+// Delete deletes item at `key` from memory and from the store
+func (c *EncodedCache[Key, Value]) Delete(ctx context.Context, key Key) bool {
+	c.mu.Lock()
+	_, found := c.pending[key]
+	if c.stored.remove(key) {
+		found = true
+	}
+	delete(c.pending, key)
+	delete(c.prefetched, key)
+	c.tags.remove(key)
+	c.mu.Unlock()
+
+	if err := c.store.Delete(ctx, c.keyFunc(key)); err != nil {
+		c.onError(err)
+	}
+	return found
+}
+
+// Clear clears the keys kept in memory, and deletes the keys this process remembers storing from the store
+// (see WithStoredKeys). The keys stored by other processes sharing the store are left until they expire.
+func (c *EncodedCache[Key, Value]) Clear() {
+	c.mu.Lock()
+	keys := c.stored.keys(time.Now())
+	c.pending = make(map[Key]Thunk[Value])
+	c.prefetched = make(map[Key]struct{})
+	c.stored.clear()
+	c.tags.clear()
+	c.mu.Unlock()
+
+	ctx := context.Background()
+	for _, key := range keys {
+		if err := c.store.Delete(ctx, c.keyFunc(key)); err != nil {
+			c.onError(err)
+		}
+	}
+}
+
+// DeleteFunc deletes the items set in this process whose key match reports true for from memory and from
+// the store, and returns how many it deleted
+func (c *EncodedCache[Key, Value]) DeleteFunc(ctx context.Context, match func(Key) bool) int {
+	c.mu.Lock()
+	var keys []Key
+	for key := range c.pending {
+		if match(key) {
+			keys = append(keys, key)
+		}
+	}
+	for _, key := range c.stored.keys(time.Now()) {
+		if _, pending := c.pending[key]; !pending && match(key) {
+			keys = append(keys, key)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, key := range keys {
+		c.Delete(ctx, key)
+	}
+	return len(keys)
+}
+
+// Tag gives tags to the item at `key`, if it was set in this process
+func (c *EncodedCache[Key, Value]) Tag(_ context.Context, key Key, tags []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, pending := c.pending[key]
+	if pending || c.stored.has(key, time.Now()) {
+		c.tags.add(key, tags)
+	}
+}
+
+// DeleteTag deletes the items with `tag` from memory and from the store, and returns how many it deleted
+func (c *EncodedCache[Key, Value]) DeleteTag(ctx context.Context, tag string) int {
+	c.mu.Lock()
+	keys := c.tags.take(tag)
+	c.mu.Unlock()
+
+	for _, key := range keys {
+		c.Delete(ctx, key)
+	}
+	return len(keys)
+}
+
+// getMany returns the results stored for keys, with nil for the keys which aren't stored or have expired.
+func (c *EncodedCache[Key, Value]) getMany(ctx context.Context, keys []Key) []*Result[Value] {
+	results := make([]*Result[Value], len(keys))
+
+	storeKeys := make([]string, len(keys))
+	for i, key := range keys {
+		storeKeys[i] = c.keyFunc(key)
+	}
+
+	values, err := c.store.GetMulti(ctx, storeKeys)
+	if err != nil {
+		c.onError(err)
+		return results
+	}
+
+	for i, storeKey := range storeKeys {
+		data, found := values[storeKey]
+		if !found {
+			continue
+		}
+		result, expired, err := c.results.decode(keys[i], data)
+		if err != nil {
+			c.onError(err)
+			continue
+		}
+		if !expired {
+			results[i] = result
+		}
+	}
+	return results
+}
+
+// storedKeys remembers the keys written to the store until they expire from it, forgetting the oldest ones
+// beyond its capacity. It isn't safe for concurrent use.
+type storedKeys[Key comparable] struct {
+	capacity int
+	items    map[Key]*list.Element
+	order    *list.List // front is the most recently written
+}
+
+type storedKey[Key comparable] struct {
+	key     Key
+	expires time.Time
+}
+
+func newStoredKeys[Key comparable](capacity int) *storedKeys[Key] {
+	return &storedKeys[Key]{
+		capacity: capacity,
+		items:    make(map[Key]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// add remembers key until expires (never if it is zero), and returns the keys forgotten to respect the
+// capacity or because they expired
+func (s *storedKeys[Key]) add(key Key, expires time.Time) []Key {
+	s.remove(key)
+	s.items[key] = s.order.PushFront(&storedKey[Key]{key: key, expires: expires})
+
+	var forgotten []Key
+	now := time.Now()
+	for e := s.order.Back(); e != nil; e = s.order.Back() {
+		item := e.Value.(*storedKey[Key])
+		if s.order.Len() <= s.capacity && !item.expired(now) {
+			break
+		}
+		s.remove(item.key)
+		forgotten = append(forgotten, item.key)
+	}
+	return forgotten
+}
+
+// has reports whether key was stored and hasn't expired at now
+func (s *storedKeys[Key]) has(key Key, now time.Time) bool {
+	e, found := s.items[key]
+	return found && !e.Value.(*storedKey[Key]).expired(now)
+}
+
+// keys returns the stored keys which haven't expired at now
+func (s *storedKeys[Key]) keys(now time.Time) []Key {
+	keys := make([]Key, 0, len(s.items))
+	for e := s.order.Front(); e != nil; e = e.Next() {
+		if item := e.Value.(*storedKey[Key]); !item.expired(now) {
+			keys = append(keys, item.key)
+		}
+	}
+	return keys
+}
+
+// remove forgets key and reports whether it was stored
+func (s *storedKeys[Key]) remove(key Key) bool {
+	e, found := s.items[key]
+	if found {
+		s.order.Remove(e)
+		delete(s.items, key)
+	}
+	return found
+}
+
+func (s *storedKeys[Key]) clear() {
+	s.items = make(map[Key]*list.Element)
+	s.order.Init()
+}
+
+func (k *storedKey[Key]) expired(now time.Time) bool {
+	return !k.expires.IsZero() && now.After(k.expires)
+}
@@ -0,0 +1,105 @@
+package dataloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotFound is matched (using errors.Is) by the errors of keys for which no value exists.
+var ErrNotFound = errors.New("dataloader: not found")
+
+// NotFoundError is the error a key resolves to when the batch function did not return a value for it.
+// It matches ErrNotFound when used with errors.Is.
+type NotFoundError[Key any] struct {
+	Key Key
+}
+
+func (e *NotFoundError[Key]) Error() string {
+	return fmt.Sprintf("dataloader: no value found for key %v", e.Key)
+}
+
+// Is reports whether target is ErrNotFound.
+func (e *NotFoundError[Key]) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+// SplitNotFound separates the keys that were not found from the ones that failed in the errors
+// returned by a ThunkMany. notFound[i] reports whether the key at index i doesn't exist, and
+// errs contains the other errors at their index, or is nil if there are none.
+func SplitNotFound(errs []error) (notFound []bool, failed []error) {
+	notFound = make([]bool, len(errs))
+	for i, err := range errs {
+		if errors.Is(err, ErrNotFound) {
+			notFound[i] = true
+		} else if err != nil {
+			if failed == nil {
+				failed = make([]error, len(errs))
+			}
+			failed[i] = err
+		}
+	}
+	return notFound, failed
+}
+
+// BatchLengthMismatchError is the error every key in a batch resolves to when the batch function
+// returned a different number of results than it was given keys.
+type BatchLengthMismatchError[Key any] struct {
+	// Expected is the number of keys passed to the batch function.
+	Expected int
+	// Actual is the number of results it returned.
+	Actual int
+	// Keys are the keys of the batch.
+	Keys []Key
+}
+
+func (e *BatchLengthMismatchError[Key]) Error() string {
+	return fmt.Sprintf("The batch function supplied did not return an array of responses the same length as the array of keys (expected %d, got %d)", e.Expected, e.Actual)
+}
+
+// BatchPanicError is the error every key in a batch resolves to when the batch function panicked.
+type BatchPanicError struct {
+	// Value is the value recovered from the panic.
+	Value any
+	// Stack is the stack trace of the panicking goroutine.
+	Stack []byte
+}
+
+func (e *BatchPanicError) Error() string {
+	return fmt.Sprintf("Panic received in batch function: %v", e.Value)
+}
+
+// BatchTimeoutError is the error every key in a batch resolves to when the batch function
+// didn't return before the batch deadline (see WithBatchTimeout).
+// It matches context.DeadlineExceeded when used with errors.Is.
+type BatchTimeoutError struct {
+	// Deadline is the deadline the batch function missed.
+	Deadline time.Time
+}
+
+func (e *BatchTimeoutError) Error() string {
+	return fmt.Sprintf("dataloader: batch function timed out at %s", e.Deadline.Format(time.RFC3339Nano))
+}
+
+// Unwrap returns context.DeadlineExceeded.
+func (e *BatchTimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// faultyBatchFunc marks the errors caused by a faulty batch function, which are not worth retrying.
+func (e *BatchLengthMismatchError[Key]) faultyBatchFunc() {}
+func (e *BatchPanicError) faultyBatchFunc()               {}
+
+// BatchQueueFullError is the error every key in a batch resolves to when the maximum number of batches
+// were in flight and the queue of waiting batches was full (see WithBatchQueue and FailWhenFull).
+type BatchQueueFullError struct {
+	// InFlight is the number of batches whose batch function was running.
+	InFlight int
+	// Queued is the number of batches waiting for one of them to return.
+	Queued int
+}
+
+func (e *BatchQueueFullError) Error() string {
+	return fmt.Sprintf("dataloader: batch queue full (%d batches in flight, %d queued)", e.InFlight, e.Queued)
+}
@@ -11,9 +11,11 @@ import (
 	cache "github.com/patrickmn/go-cache"
 )
 
-// Cache implements the dataloader.Cache interface
+// Cache implements the dataloader.Cache and dataloader.ResultCache interfaces
 type Cache[Key ~string, Value any] struct {
 	c *cache.Cache
+	// notFoundTTL is how long keys that don't exist are cached for
+	notFoundTTL time.Duration
 }
 
 // Get gets a value from the cache
@@ -30,6 +32,16 @@ func (c *Cache[Key, Value]) Set(_ context.Context, key Key, value dataloader.Thu
 	c.c.Set(string(key), value, 0)
 }
 
+// Resolve caches keys that don't exist for a shorter time than the others
+func (c *Cache[Key, Value]) Resolve(_ context.Context, key Key, result *dataloader.Result[Value]) {
+	if !result.NotFound() {
+		return
+	}
+	if v, ok := c.c.Get(string(key)); ok {
+		c.c.Set(string(key), v, c.notFoundTTL)
+	}
+}
+
 // Delete deletes and item in the cache
 func (c *Cache[Key, Value]) Delete(_ context.Context, key Key) bool {
 	if _, found := c.c.Get(string(key)); found {
@@ -47,7 +59,7 @@ func (c *Cache[Key, Value]) Clear() {
 func ExampleTTLCache() {
 	// go-cache will automaticlly cleanup expired items on given diration
 	c := cache.New(15*time.Minute, 15*time.Minute)
-	cache := &Cache[string, string]{c}
+	cache := &Cache[string, string]{c: c, notFoundTTL: time.Minute}
 	loader := dataloader.NewBatchedLoader(batchFunc, dataloader.WithCache[string, string](cache))
 
 	// immediately call the future function from loader
@@ -0,0 +1,48 @@
+package dataloader
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/maphash"
+	"math"
+)
+
+// hashKey returns a hash of key. Common key types are hashed directly, the others are hashed
+// through their printed representation.
+func hashKey[Key comparable](seed maphash.Seed, key Key) uint64 {
+	var h maphash.Hash
+	h.SetSeed(seed)
+
+	var buf [8]byte
+	switch k := any(key).(type) {
+	case string:
+		h.WriteString(k)
+	case int:
+		binary.LittleEndian.PutUint64(buf[:], uint64(k))
+		h.Write(buf[:])
+	case int64:
+		binary.LittleEndian.PutUint64(buf[:], uint64(k))
+		h.Write(buf[:])
+	case int32:
+		binary.LittleEndian.PutUint64(buf[:], uint64(k))
+		h.Write(buf[:])
+	case uint:
+		binary.LittleEndian.PutUint64(buf[:], uint64(k))
+		h.Write(buf[:])
+	case uint64:
+		binary.LittleEndian.PutUint64(buf[:], k)
+		h.Write(buf[:])
+	case uint32:
+		binary.LittleEndian.PutUint64(buf[:], uint64(k))
+		h.Write(buf[:])
+	case float64:
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(k))
+		h.Write(buf[:])
+	case fmt.Stringer:
+		h.WriteString(k.String())
+	default:
+		fmt.Fprintf(&h, "%#v", k)
+	}
+
+	return h.Sum64()
+}
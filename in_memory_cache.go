@@ -12,6 +12,7 @@ import (
 // for long lived cached items.
 type InMemoryCache[Key comparable, Value any] struct {
 	items map[Key]Thunk[Value]
+	tags  tagIndex[Key]
 	mu    sync.RWMutex
 }
 
@@ -27,9 +28,23 @@ func NewCache[Key comparable, Value any]() *InMemoryCache[Key, Value] {
 func (c *InMemoryCache[Key, Value]) Set(_ context.Context, key Key, value Thunk[Value]) {
 	c.mu.Lock()
 	c.items[key] = value
+	c.tags.remove(key)
 	c.mu.Unlock()
 }
 
+// GetOrSet returns the value at `key` and true if it exists, otherwise it sets `value`
+// at `key` and returns it and false
+func (c *InMemoryCache[Key, Value]) GetOrSet(_ context.Context, key Key, value Thunk[Value]) (Thunk[Value], bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if item, found := c.items[key]; found {
+		return item, true
+	}
+	c.items[key] = value
+	return value, false
+}
+
 // Get gets the value at `key` if it exsits, returns value (or nil) and bool
 // indicating of value was found
 func (c *InMemoryCache[Key, Value]) Get(_ context.Context, key Key) (Thunk[Value], bool) {
@@ -50,6 +65,7 @@ func (c *InMemoryCache[Key, Value]) Delete(ctx context.Context, key Key) bool {
 		c.mu.Lock()
 		defer c.mu.Unlock()
 		delete(c.items, key)
+		c.tags.remove(key)
 		return true
 	}
 	return false
@@ -59,5 +75,43 @@ func (c *InMemoryCache[Key, Value]) Delete(ctx context.Context, key Key) bool {
 func (c *InMemoryCache[Key, Value]) Clear() {
 	c.mu.Lock()
 	c.items = map[Key]Thunk[Value]{}
+	c.tags.clear()
+	c.mu.Unlock()
+}
+
+// DeleteFunc deletes the items whose key match reports true for, and returns how many it deleted
+func (c *InMemoryCache[Key, Value]) DeleteFunc(_ context.Context, match func(Key) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	deleted := 0
+	for key := range c.items {
+		if match(key) {
+			delete(c.items, key)
+			c.tags.remove(key)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+// Tag gives tags to the item at `key`, if it exists
+func (c *InMemoryCache[Key, Value]) Tag(_ context.Context, key Key, tags []string) {
+	c.mu.Lock()
+	if _, found := c.items[key]; found {
+		c.tags.add(key, tags)
+	}
 	c.mu.Unlock()
 }
+
+// DeleteTag deletes the items with `tag`, and returns how many it deleted
+func (c *InMemoryCache[Key, Value]) DeleteTag(_ context.Context, tag string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := c.tags.take(tag)
+	for _, key := range keys {
+		delete(c.items, key)
+	}
+	return len(keys)
+}
@@ -0,0 +1,203 @@
+package dataloader
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+)
+
+// Invalidation tells the loaders subscribed to a channel which keys to clear from their cache
+// (see WithInvalidator).
+type Invalidation[Key any] struct {
+	// Source identifies the loader which published the invalidation, which ignores it
+	Source string `json:"source"`
+	// Keys to clear
+	Keys []Key `json:"keys,omitempty"`
+	// Tags whose keys to clear (see Loader.ClearTag)
+	Tags []string `json:"tags,omitempty"`
+	// All clears the entire cache
+	All bool `json:"all,omitempty"`
+}
+
+// Invalidator publishes invalidations to the loaders subscribed to a channel, which may live in other
+// processes. Adapters for message buses like Redis pub/sub or NATS can be built with NewBusInvalidator.
+type Invalidator[Key any] interface {
+	// Publish sends invalidation to every subscriber of channel.
+	Publish(ctx context.Context, channel string, invalidation Invalidation[Key]) error
+	// Subscribe calls handler with every invalidation published to channel until unsubscribe is called.
+	Subscribe(channel string, handler func(context.Context, Invalidation[Key])) (unsubscribe func(), err error)
+}
+
+// MemoryInvalidator is an in process implementation of the Invalidator interface, for loaders
+// sharing a process. Invalidations are delivered before Publish returns.
+type MemoryInvalidator[Key any] struct {
+	mu          sync.Mutex
+	subscribers map[string]map[int]func(context.Context, Invalidation[Key])
+	next        int
+}
+
+// NewMemoryInvalidator constructs a new MemoryInvalidator
+func NewMemoryInvalidator[Key any]() *MemoryInvalidator[Key] {
+	return &MemoryInvalidator[Key]{subscribers: make(map[string]map[int]func(context.Context, Invalidation[Key]))}
+}
+
+// Publish calls the handlers subscribed to channel with invalidation
+func (i *MemoryInvalidator[Key]) Publish(ctx context.Context, channel string, invalidation Invalidation[Key]) error {
+	i.mu.Lock()
+	handlers := make([]func(context.Context, Invalidation[Key]), 0, len(i.subscribers[channel]))
+	for _, handler := range i.subscribers[channel] {
+		handlers = append(handlers, handler)
+	}
+	i.mu.Unlock()
+
+	for _, handler := range handlers {
+		handler(ctx, invalidation)
+	}
+	return nil
+}
+
+// Subscribe calls handler with every invalidation published to channel until unsubscribe is called
+func (i *MemoryInvalidator[Key]) Subscribe(channel string, handler func(context.Context, Invalidation[Key])) (func(), error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	id := i.next
+	i.next++
+	if i.subscribers[channel] == nil {
+		i.subscribers[channel] = make(map[int]func(context.Context, Invalidation[Key]))
+	}
+	i.subscribers[channel][id] = handler
+
+	return func() {
+		i.mu.Lock()
+		delete(i.subscribers[channel], id)
+		if len(i.subscribers[channel]) == 0 {
+			delete(i.subscribers, channel)
+		}
+		i.mu.Unlock()
+	}, nil
+}
+
+// MessageBus is a publish/subscribe bus of bytes shared by many processes, e.g. Redis pub/sub or NATS.
+type MessageBus interface {
+	// Publish sends message to every subscriber of channel
+	Publish(ctx context.Context, channel string, message []byte) error
+	// Subscribe calls handler with every message published to channel until unsubscribe is called
+	Subscribe(channel string, handler func(context.Context, []byte)) (unsubscribe func(), err error)
+}
+
+// BusInvalidator is an implementation of the Invalidator interface on top of a MessageBus,
+// serializing invalidations with a Codec.
+type BusInvalidator[Key any] struct {
+	bus   MessageBus
+	codec Codec[Invalidation[Key]]
+}
+
+// NewBusInvalidator constructs a new BusInvalidator publishing to bus, serializing invalidations with codec.
+func NewBusInvalidator[Key any](bus MessageBus, codec Codec[Invalidation[Key]]) *BusInvalidator[Key] {
+	return &BusInvalidator[Key]{bus: bus, codec: codec}
+}
+
+// Publish encodes invalidation and publishes it to channel
+func (i *BusInvalidator[Key]) Publish(ctx context.Context, channel string, invalidation Invalidation[Key]) error {
+	message, err := i.codec.Marshal(invalidation)
+	if err != nil {
+		return err
+	}
+	return i.bus.Publish(ctx, channel, message)
+}
+
+// Subscribe calls handler with every invalidation published to channel until unsubscribe is called.
+// Since the keys of a message which can't be decoded are unknown, it is delivered as an invalidation
+// of the entire cache.
+func (i *BusInvalidator[Key]) Subscribe(channel string, handler func(context.Context, Invalidation[Key])) (func(), error) {
+	return i.bus.Subscribe(channel, func(ctx context.Context, message []byte) {
+		invalidation, err := i.codec.Unmarshal(message)
+		if err != nil {
+			invalidation = Invalidation[Key]{All: true}
+		}
+		handler(ctx, invalidation)
+	})
+}
+
+// invalidate clears the keys of invalidation from the cache. Tags are cleared like ClearTag does.
+func (l *Loader[Key, Value]) invalidate(ctx context.Context, invalidation Invalidation[Key]) {
+	cleared := func(key Key) bool {
+		if invalidation.All {
+			return true
+		}
+		for _, k := range invalidation.Keys {
+			if k == key {
+				return true
+			}
+		}
+		return false
+	}
+	// the keys of tags aren't known, so every reload in the background is stopped.
+	// Keys being loaded aren't tagged yet.
+	l.stopRefresh(func(key Key) bool { return len(invalidation.Tags) > 0 || cleared(key) })
+	if invalidation.All {
+		l.pending.forget(cleared)
+	} else {
+		for _, key := range invalidation.Keys {
+			l.pending.forgetKey(key)
+		}
+	}
+
+	l.cacheLock.Lock()
+	defer l.cacheLock.Unlock()
+
+	if invalidation.All {
+		l.cache.Clear()
+		return
+	}
+
+	for _, key := range invalidation.Keys {
+		l.cache.Delete(ctx, key)
+	}
+
+	if len(invalidation.Tags) == 0 {
+		return
+	}
+	tagCache, ok := l.cache.(TagCache[Key, Value])
+	if !ok {
+		l.cache.Clear()
+		return
+	}
+	for _, tag := range invalidation.Tags {
+		tagCache.DeleteTag(ctx, tag)
+	}
+}
+
+// publish sends invalidation to the other loaders subscribed to the channel of the invalidator, if any.
+func (l *Loader[Key, Value]) publish(ctx context.Context, invalidation Invalidation[Key]) {
+	if l.invalidator == nil {
+		return
+	}
+
+	invalidation.Source = l.id
+	if err := l.invalidator.Publish(ctx, l.invalidationChannel, invalidation); err != nil {
+		l.logger.Printf("Dataloader: failed to publish invalidation to %s: %v", l.invalidationChannel, err)
+	}
+}
+
+// subscribe clears the keys published by the other loaders to the channel of the invalidator.
+func (l *Loader[Key, Value]) subscribe() {
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		l.logger.Printf("Dataloader: failed to generate loader id: %v", err)
+	}
+	l.id = hex.EncodeToString(id[:])
+
+	unsubscribe, err := l.invalidator.Subscribe(l.invalidationChannel, func(ctx context.Context, invalidation Invalidation[Key]) {
+		if invalidation.Source != l.id {
+			l.invalidate(ctx, invalidation)
+		}
+	})
+	if err != nil {
+		l.logger.Printf("Dataloader: failed to subscribe to %s: %v", l.invalidationChannel, err)
+		return
+	}
+	l.unsubscribe = unsubscribe
+}
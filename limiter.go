@@ -0,0 +1,163 @@
+package dataloader
+
+import "sync"
+
+// QueueFullPolicy is what a Loader does with a batch when the maximum number of batches are in flight and the
+// queue of waiting batches is full (see WithMaxConcurrentBatches and WithBatchQueue).
+type QueueFullPolicy int
+
+const (
+	// BlockWhenFull blocks the calls to Load until a batch leaves the queue.
+	BlockWhenFull QueueFullPolicy = iota
+	// MergeWhenFull merges the batch into the last batch of the queue, up to the batch capacity
+	// (see WithBatchCapacity). The keys the last batch already has aren't added again, and the
+	// keys beyond its capacity wait as a batch of their own, even though the queue is full.
+	MergeWhenFull
+	// FailWhenFull resolves every key of the batch with a *BatchQueueFullError.
+	FailWhenFull
+)
+
+// batchLimiter bounds the number of batches whose batch function runs concurrently. The batches beyond
+// the limit wait in a queue, in the order they were dispatched.
+type batchLimiter[Key comparable, Value any] struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	max      int
+	inFlight int
+	// the maximum number of waiting batches. Set to 0 if you want it to be unbounded.
+	queueCap int
+	policy   QueueFullPolicy
+	// the maximum number of requests of a batch merged into (see MergeWhenFull), 0 if it is unbounded.
+	batchCap int
+	waiting  []*waitingBatch[Key, Value]
+}
+
+type waitingBatch[Key comparable, Value any] struct {
+	reqs []*batchRequest[Key, Value]
+	// requests merged into the batch for a key it already had
+	duplicates []duplicateRequest[Key, Value]
+	// the index of the request of each key, built once a batch is merged into it
+	index map[Key]int
+}
+
+// duplicateRequest is a request which resolves to the item of the request at index in its batch.
+type duplicateRequest[Key comparable, Value any] struct {
+	req   *batchRequest[Key, Value]
+	index int
+}
+
+// merge adds the requests of reqs whose key the batch doesn't have yet, up to capacity requests
+// (unbounded if it is 0), and returns the ones which didn't fit.
+func (w *waitingBatch[Key, Value]) merge(reqs []*batchRequest[Key, Value], capacity int) []*batchRequest[Key, Value] {
+	if w.index == nil {
+		w.index = make(map[Key]int, len(w.reqs))
+		for i, req := range w.reqs {
+			w.index[req.key] = i
+		}
+	}
+
+	var rest []*batchRequest[Key, Value]
+	for _, req := range reqs {
+		if i, found := w.index[req.key]; found {
+			w.duplicates = append(w.duplicates, duplicateRequest[Key, Value]{req: req, index: i})
+			continue
+		}
+		if capacity > 0 && len(w.reqs) >= capacity {
+			rest = append(rest, req)
+			continue
+		}
+		w.index[req.key] = len(w.reqs)
+		w.reqs = append(w.reqs, req)
+	}
+	return rest
+}
+
+func newBatchLimiter[Key comparable, Value any](max int) *batchLimiter[Key, Value] {
+	l := &batchLimiter[Key, Value]{max: max}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// full reports whether the queue is full. It must be called with the lock held.
+func (l *batchLimiter[Key, Value]) full() bool {
+	return l.queueCap > 0 && len(l.waiting) >= l.queueCap
+}
+
+// queueFull reports whether the queue is full.
+func (l *batchLimiter[Key, Value]) queueFull() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.full()
+}
+
+// admit blocks while the queue is full, if the policy says so. It is called by Load.
+func (l *batchLimiter[Key, Value]) admit() {
+	if l.policy != BlockWhenFull {
+		return
+	}
+
+	l.mu.Lock()
+	for l.full() {
+		l.cond.Wait()
+	}
+	l.mu.Unlock()
+}
+
+// acquire blocks until the batch of reqs may call the batch function, and returns it, including the
+// requests of the batches merged into it. It returns nil if the batch was merged into a waiting batch,
+// and a *BatchQueueFullError if it was rejected. Unless it was rejected, release must be called once
+// the batch function returned.
+func (l *batchLimiter[Key, Value]) acquire(reqs []*batchRequest[Key, Value]) (*waitingBatch[Key, Value], error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for {
+		if (l.max <= 0 || l.inFlight < l.max) && len(l.waiting) == 0 {
+			l.inFlight++
+			return &waitingBatch[Key, Value]{reqs: reqs}, nil
+		}
+		if !l.full() {
+			break
+		}
+
+		if l.policy == MergeWhenFull {
+			if reqs = l.waiting[len(l.waiting)-1].merge(reqs, l.batchCap); len(reqs) == 0 {
+				return nil, nil
+			}
+			// the requests beyond the capacity of the last batch wait as a batch of their own
+			break
+		}
+		if l.policy == FailWhenFull {
+			return nil, &BatchQueueFullError{InFlight: l.inFlight, Queued: len(l.waiting)}
+		}
+		// BlockWhenFull: wait until a batch leaves the queue
+		l.cond.Wait()
+	}
+
+	w := &waitingBatch[Key, Value]{reqs: reqs}
+	l.waiting = append(l.waiting, w)
+	for (l.max > 0 && l.inFlight >= l.max) || l.waiting[0] != w {
+		l.cond.Wait()
+	}
+	l.waiting = l.waiting[1:]
+	l.inFlight++
+	// wake up the calls to Load waiting for the queue
+	l.cond.Broadcast()
+	return w, nil
+}
+
+// release lets the next waiting batch call the batch function.
+func (l *batchLimiter[Key, Value]) release() {
+	l.mu.Lock()
+	l.inFlight--
+	l.cond.Broadcast()
+	l.mu.Unlock()
+}
+
+// stats returns the number of batches in flight and waiting.
+func (l *batchLimiter[Key, Value]) stats() (inFlight, queued int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight, len(l.waiting)
+}
@@ -0,0 +1,305 @@
+package dataloader
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+// CacheStats are the counters of a size bounded cache.
+type CacheStats struct {
+	// Hits is the number of calls to Get which found the key
+	Hits uint64
+	// Misses is the number of calls to Get which didn't find the key
+	Misses uint64
+	// Evictions is the number of items removed to respect the capacity of the cache
+	Evictions uint64
+	// Rejections is the number of items the cache declined to store (TinyLFUCache only)
+	Rejections uint64
+}
+
+// BoundedCacheOption allows for configuration of the size bounded caches
+// (LRUCache and TinyLFUCache).
+type BoundedCacheOption[Key comparable, Value any] func(*boundedCacheOptions[Key, Value])
+
+type boundedCacheOptions[Key comparable, Value any] struct {
+	cost    func(Key, *Result[Value]) int64
+	onEvict func(Key, Thunk[Value])
+}
+
+// WithCost sets the cost of an item from the result it resolved to. The capacity of the cache is
+// then the maximum total cost of its items rather than their count. Items cost 1 until they resolve.
+func WithCost[Key comparable, Value any](fn func(Key, *Result[Value]) int64) BoundedCacheOption[Key, Value] {
+	return func(o *boundedCacheOptions[Key, Value]) {
+		o.cost = fn
+	}
+}
+
+// WithEvictionCallback sets a function called with every item removed to respect the capacity of the cache.
+// It is called after the cache is unlocked, so it may use the cache.
+func WithEvictionCallback[Key comparable, Value any](fn func(Key, Thunk[Value])) BoundedCacheOption[Key, Value] {
+	return func(o *boundedCacheOptions[Key, Value]) {
+		o.onEvict = fn
+	}
+}
+
+// LRUCache is an in memory implementation of the Cache interface bounded in size.
+// Once its capacity is reached, the least recently used items are evicted.
+type LRUCache[Key comparable, Value any] struct {
+	mu    sync.Mutex
+	lru   *lruList[Key, Value]
+	stats CacheStats
+	boundedCacheOptions[Key, Value]
+}
+
+// NewLRUCache constructs a new LRUCache holding up to capacity items
+// (or items of capacity total cost, see WithCost).
+func NewLRUCache[Key comparable, Value any](capacity int64, opts ...BoundedCacheOption[Key, Value]) *LRUCache[Key, Value] {
+	c := &LRUCache[Key, Value]{lru: newLRUList[Key, Value](capacity)}
+	for _, apply := range opts {
+		apply(&c.boundedCacheOptions)
+	}
+	return c
+}
+
+// Get gets the value at `key` if it exsits, returns value (or nil) and bool
+// indicating of value was found
+func (c *LRUCache[Key, Value]) Get(_ context.Context, key Key) (Thunk[Value], bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, found := c.lru.get(key)
+	if !found {
+		c.stats.Misses++
+		return nil, false
+	}
+	c.stats.Hits++
+	return item.thunk, true
+}
+
+// Set sets the `value` at `key` in the cache, evicting the least recently used items if needed
+func (c *LRUCache[Key, Value]) Set(_ context.Context, key Key, value Thunk[Value]) {
+	c.mu.Lock()
+	c.lru.set(key, value)
+	evicted := c.lru.evict()
+	c.stats.Evictions += uint64(len(evicted))
+	c.mu.Unlock()
+
+	c.evicted(evicted)
+}
+
+// GetOrSet returns the value at `key` and true if it exists, otherwise it sets `value`
+// at `key`, evicting the least recently used items if needed, and returns it and false
+func (c *LRUCache[Key, Value]) GetOrSet(_ context.Context, key Key, value Thunk[Value]) (Thunk[Value], bool) {
+	c.mu.Lock()
+	if item, found := c.lru.get(key); found {
+		c.stats.Hits++
+		c.mu.Unlock()
+		return item.thunk, true
+	}
+	c.stats.Misses++
+	c.lru.set(key, value)
+	evicted := c.lru.evict()
+	c.stats.Evictions += uint64(len(evicted))
+	c.mu.Unlock()
+
+	c.evicted(evicted)
+	return value, false
+}
+
+// Resolve updates the cost of the item at `key` from the result it resolved to, if a cost function is set.
+func (c *LRUCache[Key, Value]) Resolve(_ context.Context, key Key, result *Result[Value]) {
+	if c.cost == nil {
+		return
+	}
+
+	c.mu.Lock()
+	c.lru.setCost(key, c.cost(key, result))
+	evicted := c.lru.evict()
+	c.stats.Evictions += uint64(len(evicted))
+	c.mu.Unlock()
+
+	c.evicted(evicted)
+}
+
+// Delete deletes item at `key` from cache
+func (c *LRUCache[Key, Value]) Delete(_ context.Context, key Key) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.remove(key)
+}
+
+// Clear clears the entire cache
+func (c *LRUCache[Key, Value]) Clear() {
+	c.mu.Lock()
+	c.lru.clear()
+	c.mu.Unlock()
+}
+
+// DeleteFunc deletes the items whose key match reports true for, and returns how many it deleted
+func (c *LRUCache[Key, Value]) DeleteFunc(_ context.Context, match func(Key) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.removeFunc(match)
+}
+
+// Tag gives tags to the item at `key`, if it exists
+func (c *LRUCache[Key, Value]) Tag(_ context.Context, key Key, tags []string) {
+	c.mu.Lock()
+	c.lru.tag(key, tags)
+	c.mu.Unlock()
+}
+
+// DeleteTag deletes the items with `tag`, and returns how many it deleted
+func (c *LRUCache[Key, Value]) DeleteTag(_ context.Context, tag string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.removeTag(tag)
+}
+
+// Len returns the number of items in the cache
+func (c *LRUCache[Key, Value]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.lru.items)
+}
+
+// Stats returns the counters of the cache
+func (c *LRUCache[Key, Value]) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+func (o *boundedCacheOptions[Key, Value]) evicted(items []*lruItem[Key, Value]) {
+	if o.onEvict == nil {
+		return
+	}
+	for _, item := range items {
+		o.onEvict(item.key, item.thunk)
+	}
+}
+
+type lruItem[Key comparable, Value any] struct {
+	key   Key
+	thunk Thunk[Value]
+	cost  int64
+}
+
+// lruList keeps items in least recently used order. It isn't safe for concurrent use.
+type lruList[Key comparable, Value any] struct {
+	capacity int64
+	cost     int64
+	items    map[Key]*list.Element
+	order    *list.List // front is the most recently used
+	tags     tagIndex[Key]
+}
+
+func newLRUList[Key comparable, Value any](capacity int64) *lruList[Key, Value] {
+	return &lruList[Key, Value]{
+		capacity: capacity,
+		items:    make(map[Key]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (l *lruList[Key, Value]) get(key Key) (*lruItem[Key, Value], bool) {
+	e, found := l.items[key]
+	if !found {
+		return nil, false
+	}
+	l.order.MoveToFront(e)
+	return e.Value.(*lruItem[Key, Value]), true
+}
+
+func (l *lruList[Key, Value]) set(key Key, thunk Thunk[Value]) {
+	if e, found := l.items[key]; found {
+		item := e.Value.(*lruItem[Key, Value])
+		l.cost += 1 - item.cost
+		item.thunk = thunk
+		item.cost = 1
+		l.order.MoveToFront(e)
+		l.tags.remove(key)
+		return
+	}
+	l.items[key] = l.order.PushFront(&lruItem[Key, Value]{key: key, thunk: thunk, cost: 1})
+	l.cost++
+}
+
+func (l *lruList[Key, Value]) setCost(key Key, cost int64) {
+	if e, found := l.items[key]; found {
+		item := e.Value.(*lruItem[Key, Value])
+		l.cost += cost - item.cost
+		item.cost = cost
+	}
+}
+
+// full reports whether adding an item of the given cost would exceed the capacity.
+func (l *lruList[Key, Value]) full(cost int64) bool {
+	return l.cost+cost > l.capacity
+}
+
+// oldest returns the least recently used item
+func (l *lruList[Key, Value]) oldest() *lruItem[Key, Value] {
+	if e := l.order.Back(); e != nil {
+		return e.Value.(*lruItem[Key, Value])
+	}
+	return nil
+}
+
+// evict removes the least recently used items until the capacity is respected
+// and returns them.
+func (l *lruList[Key, Value]) evict() []*lruItem[Key, Value] {
+	var evicted []*lruItem[Key, Value]
+	for l.cost > l.capacity && l.order.Len() > 0 {
+		item := l.oldest()
+		l.remove(item.key)
+		evicted = append(evicted, item)
+	}
+	return evicted
+}
+
+func (l *lruList[Key, Value]) remove(key Key) bool {
+	e, found := l.items[key]
+	if !found {
+		return false
+	}
+	l.order.Remove(e)
+	delete(l.items, key)
+	l.tags.remove(key)
+	l.cost -= e.Value.(*lruItem[Key, Value]).cost
+	return true
+}
+
+func (l *lruList[Key, Value]) removeFunc(match func(Key) bool) int {
+	removed := 0
+	for key := range l.items {
+		if match(key) && l.remove(key) {
+			removed++
+		}
+	}
+	return removed
+}
+
+func (l *lruList[Key, Value]) tag(key Key, tags []string) {
+	if _, found := l.items[key]; found {
+		l.tags.add(key, tags)
+	}
+}
+
+func (l *lruList[Key, Value]) removeTag(tag string) int {
+	removed := 0
+	for _, key := range l.tags.take(tag) {
+		if l.remove(key) {
+			removed++
+		}
+	}
+	return removed
+}
+
+func (l *lruList[Key, Value]) clear() {
+	l.items = make(map[Key]*list.Element)
+	l.order.Init()
+	l.tags.clear()
+	l.cost = 0
+}
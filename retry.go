@@ -0,0 +1,123 @@
+package dataloader
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy configures how keys that failed are retried by the loader (see WithRetry).
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times the batch function is called for a key,
+	// including the first call.
+	MaxAttempts int
+
+	// InitialBackoff is the amount of time to wait before the first retry.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the amount of time to wait between two attempts. Set to 0 if you want it to be unbounded.
+	MaxBackoff time.Duration
+
+	// Multiplier is the factor the backoff grows by after each attempt. Default is 2.
+	Multiplier float64
+
+	// Jitter is the fraction (between 0 and 1) of each backoff that is randomized,
+	// to avoid retries of many loaders happening at the same time.
+	Jitter float64
+
+	// Retryable reports whether a key that failed with err should be retried.
+	// Default is DefaultRetryable.
+	Retryable func(err error) bool
+}
+
+// DefaultRetryable retries every error except ErrNotFound, context errors, and the errors
+// caused by a faulty batch function (*BatchPanicError and *BatchLengthMismatchError).
+func DefaultRetryable(err error) bool {
+	var faulty interface{ faultyBatchFunc() }
+	return err != nil &&
+		!errors.Is(err, ErrNotFound) &&
+		!isContextError(err) &&
+		!errors.As(err, &faulty)
+}
+
+// backoff returns the amount of time to wait before the given retry (starting at 1).
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+
+	d := float64(p.InitialBackoff) * math.Pow(multiplier, float64(retry-1))
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	d -= d * p.Jitter * rand.Float64()
+	return time.Duration(d)
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return err != nil && p.Retryable(err)
+	}
+	return DefaultRetryable(err)
+}
+
+type attemptContextKey struct{}
+
+// BatchAttempt returns the attempt number (starting at 1) of the batch function call ctx was passed to.
+// It can be used by the batch function or a Tracer to tell retries (see WithRetry) apart.
+func BatchAttempt(ctx context.Context) int {
+	if attempt, ok := ctx.Value(attemptContextKey{}).(int); ok {
+		return attempt
+	}
+	return 1
+}
+
+// callWithRetry invokes the batch function, then calls it again with only the keys that failed
+// with a retryable error, until they succeed, the policy is exhausted or ctx is done.
+// Every retry is traced as its own batch.
+func (b *batcher[Key, Value]) callWithRetry(ctx context.Context, keys []Key) []*Result[Value] {
+	items := b.call(ctx, keys)
+	if b.retry == nil {
+		return items
+	}
+
+	// don't modify the slice returned by the batch function
+	items = append([]*Result[Value](nil), items...)
+
+	for attempt := 2; attempt <= b.retry.MaxAttempts; attempt++ {
+		var (
+			failed     []int
+			failedKeys []Key
+		)
+		for i, item := range items {
+			if item != nil && b.retry.retryable(item.Error) {
+				failed = append(failed, i)
+				failedKeys = append(failedKeys, keys[i])
+			}
+		}
+		if len(failed) == 0 {
+			break
+		}
+
+		timer := time.NewTimer(b.retry.backoff(attempt - 1))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return items
+		case <-timer.C:
+		}
+
+		attemptCtx, finish := b.tracer.TraceBatch(context.WithValue(ctx, attemptContextKey{}, attempt), failedKeys)
+		retried := b.call(attemptCtx, failedKeys)
+		finish(retried)
+
+		for j, i := range failed {
+			items[i] = retried[j]
+		}
+	}
+
+	return items
+}
@@ -0,0 +1,265 @@
+package dataloader
+
+import (
+	"sync"
+	"time"
+)
+
+// BatchScheduler decides when the keys collected by a Loader are dispatched to the batch function.
+type BatchScheduler interface {
+	// Schedule is called when a new batch starts collecting keys, and returns its window. dispatch
+	// ends the batch and calls the batch function with it. It may be called from any goroutine and
+	// more than once, but not before Schedule returns.
+	Schedule(dispatch func()) BatchWindow
+}
+
+// BatchWindow is the schedule of a single batch, returned by a BatchScheduler.
+type BatchWindow interface {
+	// Added is called with the size of the batch each time a key is added to it. The batch is
+	// dispatched if it returns true.
+	Added(size int) bool
+	// Stop is called once the batch is dispatched, so that the window can release its resources.
+	// It must not wait for a call to dispatch to return.
+	Stop()
+}
+
+// WindowScheduler is implemented by schedulers which dispatch batches after a window of time,
+// which the Loader reports in its stats (see Loader.Stats).
+type WindowScheduler interface {
+	BatchScheduler
+	// Window returns the window of the next batch.
+	Window() time.Duration
+}
+
+// BatchObserver may be implemented by a BatchScheduler to be told how long the batch function took
+// for each batch, e.g. to adapt its window (see AdaptiveScheduler).
+type BatchObserver interface {
+	BatchDone(size int, latency time.Duration)
+}
+
+// FixedWindowScheduler dispatches batches a fixed amount of time after their first key was added.
+// It is the default scheduler, waiting for the duration set by WithWait.
+type FixedWindowScheduler struct {
+	wait time.Duration
+}
+
+// NewFixedWindowScheduler constructs a new FixedWindowScheduler dispatching batches wait after their first key.
+func NewFixedWindowScheduler(wait time.Duration) *FixedWindowScheduler {
+	return &FixedWindowScheduler{wait: wait}
+}
+
+// Schedule dispatches the batch once the wait has elapsed
+func (s *FixedWindowScheduler) Schedule(dispatch func()) BatchWindow {
+	return &timerWindow{timer: time.AfterFunc(s.wait, dispatch)}
+}
+
+// Window returns the wait
+func (s *FixedWindowScheduler) Window() time.Duration {
+	return s.wait
+}
+
+// IdleScheduler dispatches batches once no key was added to them for some time, so that a burst of
+// calls to Load is batched without waiting any longer once it's over. Under steady traffic a batch
+// may keep growing, which WithBatchCapacity bounds.
+type IdleScheduler struct {
+	idle time.Duration
+}
+
+// NewIdleScheduler constructs a new IdleScheduler dispatching batches once no key was added for idle.
+func NewIdleScheduler(idle time.Duration) *IdleScheduler {
+	return &IdleScheduler{idle: idle}
+}
+
+// Schedule dispatches the batch once no key was added to it for the idle time
+func (s *IdleScheduler) Schedule(dispatch func()) BatchWindow {
+	return &timerWindow{timer: time.AfterFunc(s.idle, dispatch), idle: s.idle}
+}
+
+// Window returns the idle time
+func (s *IdleScheduler) Window() time.Duration {
+	return s.idle
+}
+
+// MaxLatencyScheduler dispatches batches once their first key has waited for a maximum latency,
+// or as soon as they hold a maximum number of keys.
+type MaxLatencyScheduler struct {
+	maxLatency time.Duration
+	maxSize    int
+}
+
+// NewMaxLatencyScheduler constructs a new MaxLatencyScheduler dispatching batches maxLatency after their
+// first key, or once they hold maxSize keys. Set maxSize to 0 if you want it to be unbounded.
+func NewMaxLatencyScheduler(maxLatency time.Duration, maxSize int) *MaxLatencyScheduler {
+	return &MaxLatencyScheduler{maxLatency: maxLatency, maxSize: maxSize}
+}
+
+// Schedule dispatches the batch once the maximum latency has elapsed or it holds the maximum number of keys
+func (s *MaxLatencyScheduler) Schedule(dispatch func()) BatchWindow {
+	return &timerWindow{timer: time.AfterFunc(s.maxLatency, dispatch), maxSize: s.maxSize}
+}
+
+// Window returns the maximum latency
+func (s *MaxLatencyScheduler) Window() time.Duration {
+	return s.maxLatency
+}
+
+// the weight of the latest sample in the moving averages of AdaptiveScheduler
+const adaptiveSmoothing = 0.2
+
+// AdaptiveScheduler dispatches batches after a window it tunes between a minimum and a maximum from the
+// observed traffic. The window is half the average latency of the batch function, since waiting longer would
+// add more latency than batching saves, as long as keys arrive more often than that. At low traffic, when no
+// other key is expected within the window, batches are dispatched after the minimum window.
+type AdaptiveScheduler struct {
+	min, max time.Duration
+
+	mu     sync.Mutex
+	window time.Duration
+	// moving averages of the time between two keys, and of the latency of the batch function
+	interArrival time.Duration
+	latency      time.Duration
+	lastArrival  time.Time
+}
+
+// NewAdaptiveScheduler constructs a new AdaptiveScheduler whose window is between min and max.
+// It starts with the minimum window.
+func NewAdaptiveScheduler(min, max time.Duration) *AdaptiveScheduler {
+	return &AdaptiveScheduler{min: min, max: max, window: min}
+}
+
+// Schedule dispatches the batch once the current window has elapsed
+func (s *AdaptiveScheduler) Schedule(dispatch func()) BatchWindow {
+	return &adaptiveWindow{
+		timerWindow: timerWindow{timer: time.AfterFunc(s.Window(), dispatch)},
+		scheduler:   s,
+	}
+}
+
+// Window returns the current window
+func (s *AdaptiveScheduler) Window() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.window
+}
+
+// BatchDone records the latency of the batch function
+func (s *AdaptiveScheduler) BatchDone(_ int, latency time.Duration) {
+	s.mu.Lock()
+	s.latency = movingAverage(s.latency, latency)
+	s.adapt()
+	s.mu.Unlock()
+}
+
+// arrived records the arrival of a key at now
+func (s *AdaptiveScheduler) arrived(now time.Time) {
+	s.mu.Lock()
+	if !s.lastArrival.IsZero() {
+		s.interArrival = movingAverage(s.interArrival, now.Sub(s.lastArrival))
+	}
+	s.lastArrival = now
+	s.adapt()
+	s.mu.Unlock()
+}
+
+// adapt computes the window from the averages. It must be called with the lock held.
+func (s *AdaptiveScheduler) adapt() {
+	window := s.latency / 2
+	if s.interArrival == 0 || s.interArrival > window {
+		window = s.min
+	}
+
+	if window < s.min {
+		window = s.min
+	}
+	if window > s.max {
+		window = s.max
+	}
+	s.window = window
+}
+
+func movingAverage(average, sample time.Duration) time.Duration {
+	if average == 0 {
+		return sample
+	}
+	return average + time.Duration(adaptiveSmoothing*float64(sample-average))
+}
+
+type adaptiveWindow struct {
+	timerWindow
+	scheduler *AdaptiveScheduler
+}
+
+func (w *adaptiveWindow) Added(size int) bool {
+	w.scheduler.arrived(time.Now())
+	return w.timerWindow.Added(size)
+}
+
+// timerWindow dispatches its batch when its timer fires, or once it holds maxSize keys.
+type timerWindow struct {
+	timer *time.Timer
+	// the maximum size of the batch. Set to 0 if you want it to be unbounded.
+	maxSize int
+	// if set, the timer restarts each time a key is added
+	idle time.Duration
+}
+
+func (w *timerWindow) Added(size int) bool {
+	if w.idle > 0 {
+		w.timer.Reset(w.idle)
+	}
+	return w.maxSize > 0 && size >= w.maxSize
+}
+
+func (w *timerWindow) Stop() {
+	w.timer.Stop()
+}
+
+// ManualScheduler never dispatches batches by itself: they are dispatched when Dispatch is called, e.g.
+// once a level of a GraphQL query has been resolved. A ManualScheduler may be shared by many loaders.
+type ManualScheduler struct {
+	mu      sync.Mutex
+	windows map[*manualWindow]struct{}
+}
+
+// NewManualScheduler constructs a new ManualScheduler
+func NewManualScheduler() *ManualScheduler {
+	return &ManualScheduler{windows: make(map[*manualWindow]struct{})}
+}
+
+// Schedule keeps the batch until Dispatch is called
+func (s *ManualScheduler) Schedule(dispatch func()) BatchWindow {
+	w := &manualWindow{scheduler: s, dispatch: dispatch}
+	s.mu.Lock()
+	s.windows[w] = struct{}{}
+	s.mu.Unlock()
+	return w
+}
+
+// Dispatch dispatches every batch scheduled by the scheduler
+func (s *ManualScheduler) Dispatch() {
+	s.mu.Lock()
+	windows := make([]*manualWindow, 0, len(s.windows))
+	for w := range s.windows {
+		windows = append(windows, w)
+	}
+	s.mu.Unlock()
+
+	for _, w := range windows {
+		w.dispatch()
+	}
+}
+
+type manualWindow struct {
+	scheduler *ManualScheduler
+	dispatch  func()
+}
+
+func (w *manualWindow) Added(int) bool {
+	return false
+}
+
+func (w *manualWindow) Stop() {
+	w.scheduler.mu.Lock()
+	delete(w.scheduler.windows, w)
+	w.scheduler.mu.Unlock()
+}
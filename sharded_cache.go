@@ -0,0 +1,150 @@
+package dataloader
+
+import (
+	"context"
+	"hash/maphash"
+	"runtime"
+	"sync"
+)
+
+// ShardedCache is an in memory implementation of the Cache and AtomicCache interfaces for loaders
+// under heavy concurrent use. Keys are spread over shards which are locked independently, so
+// concurrent calls to Load on different keys rarely contend. Like InMemoryCache, it never expires anything.
+type ShardedCache[Key comparable, Value any] struct {
+	shards []*cacheShard[Key, Value]
+	mask   uint64
+	seed   maphash.Seed
+}
+
+type cacheShard[Key comparable, Value any] struct {
+	mu    sync.RWMutex
+	items map[Key]Thunk[Value]
+	tags  tagIndex[Key]
+}
+
+// NewShardedCache constructs a new ShardedCache with the given number of shards, rounded up to
+// a power of two. If shards is 0, four shards per CPU are used.
+func NewShardedCache[Key comparable, Value any](shards int) *ShardedCache[Key, Value] {
+	if shards <= 0 {
+		shards = 4 * runtime.GOMAXPROCS(0)
+	}
+	n := 1
+	for n < shards {
+		n <<= 1
+	}
+
+	c := &ShardedCache[Key, Value]{
+		shards: make([]*cacheShard[Key, Value], n),
+		mask:   uint64(n - 1),
+		seed:   maphash.MakeSeed(),
+	}
+	for i := range c.shards {
+		c.shards[i] = &cacheShard[Key, Value]{items: make(map[Key]Thunk[Value])}
+	}
+	return c
+}
+
+func (c *ShardedCache[Key, Value]) shard(key Key) *cacheShard[Key, Value] {
+	return c.shards[hashKey(c.seed, key)&c.mask]
+}
+
+// Get gets the value at `key` if it exsits, returns value (or nil) and bool
+// indicating of value was found
+func (c *ShardedCache[Key, Value]) Get(_ context.Context, key Key) (Thunk[Value], bool) {
+	s := c.shard(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	item, found := s.items[key]
+	return item, found
+}
+
+// Set sets the `value` at `key` in the cache
+func (c *ShardedCache[Key, Value]) Set(_ context.Context, key Key, value Thunk[Value]) {
+	s := c.shard(key)
+	s.mu.Lock()
+	s.items[key] = value
+	s.tags.remove(key)
+	s.mu.Unlock()
+}
+
+// GetOrSet returns the value at `key` and true if it exists, otherwise it sets `value`
+// at `key` and returns it and false
+func (c *ShardedCache[Key, Value]) GetOrSet(_ context.Context, key Key, value Thunk[Value]) (Thunk[Value], bool) {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if item, found := s.items[key]; found {
+		return item, true
+	}
+	s.items[key] = value
+	return value, false
+}
+
+// Delete deletes item at `key` from cache
+func (c *ShardedCache[Key, Value]) Delete(_ context.Context, key Key) bool {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, found := s.items[key]; found {
+		delete(s.items, key)
+		s.tags.remove(key)
+		return true
+	}
+	return false
+}
+
+// Clear clears the entire cache
+func (c *ShardedCache[Key, Value]) Clear() {
+	for _, s := range c.shards {
+		s.mu.Lock()
+		s.items = map[Key]Thunk[Value]{}
+		s.tags.clear()
+		s.mu.Unlock()
+	}
+}
+
+// DeleteFunc deletes the items whose key match reports true for, and returns how many it deleted.
+// The shards are locked one after the other.
+func (c *ShardedCache[Key, Value]) DeleteFunc(_ context.Context, match func(Key) bool) int {
+	deleted := 0
+	for _, s := range c.shards {
+		s.mu.Lock()
+		for key := range s.items {
+			if match(key) {
+				delete(s.items, key)
+				s.tags.remove(key)
+				deleted++
+			}
+		}
+		s.mu.Unlock()
+	}
+	return deleted
+}
+
+// Tag gives tags to the item at `key`, if it exists
+func (c *ShardedCache[Key, Value]) Tag(_ context.Context, key Key, tags []string) {
+	s := c.shard(key)
+	s.mu.Lock()
+	if _, found := s.items[key]; found {
+		s.tags.add(key, tags)
+	}
+	s.mu.Unlock()
+}
+
+// DeleteTag deletes the items with `tag`, and returns how many it deleted.
+// The shards are locked one after the other.
+func (c *ShardedCache[Key, Value]) DeleteTag(_ context.Context, tag string) int {
+	deleted := 0
+	for _, s := range c.shards {
+		s.mu.Lock()
+		for _, key := range s.tags.take(tag) {
+			delete(s.items, key)
+			deleted++
+		}
+		s.mu.Unlock()
+	}
+	return deleted
+}
@@ -0,0 +1,56 @@
+package dataloader
+
+// tagIndex maps tags to the keys of the items they were given to (see TagCache).
+// It isn't safe for concurrent use.
+type tagIndex[Key comparable] struct {
+	keys map[string]map[Key]struct{}
+	tags map[Key][]string
+}
+
+// add gives tags to the item at key
+func (t *tagIndex[Key]) add(key Key, tags []string) {
+	if t.keys == nil {
+		t.keys = make(map[string]map[Key]struct{})
+		t.tags = make(map[Key][]string)
+	}
+
+	for _, tag := range tags {
+		keys, found := t.keys[tag]
+		if !found {
+			keys = make(map[Key]struct{})
+			t.keys[tag] = keys
+		}
+		if _, tagged := keys[key]; !tagged {
+			keys[key] = struct{}{}
+			t.tags[key] = append(t.tags[key], tag)
+		}
+	}
+}
+
+// remove removes the tags of the item at key, which was deleted or replaced
+func (t *tagIndex[Key]) remove(key Key) {
+	for _, tag := range t.tags[key] {
+		delete(t.keys[tag], key)
+		if len(t.keys[tag]) == 0 {
+			delete(t.keys, tag)
+		}
+	}
+	delete(t.tags, key)
+}
+
+// take returns the keys of the items with tag, removing them from the index
+func (t *tagIndex[Key]) take(tag string) []Key {
+	keys := make([]Key, 0, len(t.keys[tag]))
+	for key := range t.keys[tag] {
+		keys = append(keys, key)
+	}
+	for _, key := range keys {
+		t.remove(key)
+	}
+	return keys
+}
+
+func (t *tagIndex[Key]) clear() {
+	t.keys = nil
+	t.tags = nil
+}
@@ -0,0 +1,172 @@
+package dataloader
+
+import (
+	"context"
+	"errors"
+	"hash/maphash"
+	"runtime"
+	"sync"
+)
+
+// pendingResult holds the result of a key until the batcher resolves it.
+type pendingResult[Value any] struct {
+	done  chan struct{}
+	value *Result[Value]
+
+	// dispatches the batch of the result once it is awaited (see WithManualDispatch)
+	mu       sync.Mutex
+	dispatch func()
+	awaited  bool
+}
+
+func newPendingResult[Value any]() *pendingResult[Value] {
+	return &pendingResult[Value]{done: make(chan struct{})}
+}
+
+// resolve sets the result and unblocks every thunk waiting on it. It must be called exactly once.
+func (p *pendingResult[Value]) resolve(value *Result[Value]) {
+	p.value = value
+	close(p.done)
+}
+
+// scheduled sets the function dispatching the batch of the result, which is called as soon
+// as a thunk of the result is awaited, or at once if one already is.
+func (p *pendingResult[Value]) scheduled(dispatch func()) {
+	p.mu.Lock()
+	p.dispatch = dispatch
+	awaited := p.awaited
+	p.mu.Unlock()
+
+	if awaited {
+		dispatch()
+	}
+}
+
+// await is called by the thunks of the result before they block, and dispatches
+// the batch of the result the first time if it is scheduled.
+func (p *pendingResult[Value]) await() {
+	p.mu.Lock()
+	if p.awaited {
+		p.mu.Unlock()
+		return
+	}
+	p.awaited = true
+	dispatch := p.dispatch
+	p.mu.Unlock()
+
+	if dispatch != nil {
+		dispatch()
+	}
+}
+
+// thunk returns a Thunk that blocks until the result is resolved or ctx is done,
+// in which case it returns ctx.Err().
+func (p *pendingResult[Value]) thunk(ctx context.Context) Thunk[Value] {
+	return func() (Value, error) {
+		select {
+		case <-p.done:
+		default:
+			p.await()
+		}
+
+		select {
+		case <-p.done:
+		case <-ctx.Done():
+			// prefer the result if it raced with the cancellation
+			select {
+			case <-p.done:
+			default:
+				var zero Value
+				return zero, ctx.Err()
+			}
+		}
+		return p.value.Data, p.value.Error
+	}
+}
+
+// pendingResults holds the pending results of the keys being loaded by a Loader, whose thunks are cached.
+// Keys are spread over shards which are locked independently, so that concurrent calls to Load on
+// different keys rarely contend.
+type pendingResults[Key comparable, Value any] struct {
+	shards []pendingShard[Key, Value]
+	mask   uint64
+	seed   maphash.Seed
+}
+
+type pendingShard[Key comparable, Value any] struct {
+	mu      sync.Mutex
+	results map[Key]*pendingResult[Value]
+}
+
+// newPendingResults constructs pendingResults with four shards per CPU, like NewShardedCache.
+func newPendingResults[Key comparable, Value any]() *pendingResults[Key, Value] {
+	n := 1
+	for n < 4*runtime.GOMAXPROCS(0) {
+		n <<= 1
+	}
+	return &pendingResults[Key, Value]{
+		shards: make([]pendingShard[Key, Value], n),
+		mask:   uint64(n - 1),
+		seed:   maphash.MakeSeed(),
+	}
+}
+
+// shard returns the shard of key, which must be locked to access its results.
+func (p *pendingResults[Key, Value]) shard(key Key) *pendingShard[Key, Value] {
+	return &p.shards[hashKey(p.seed, key)&p.mask]
+}
+
+// forget forgets the pending results of the keys match reports true for.
+func (p *pendingResults[Key, Value]) forget(match func(Key) bool) {
+	for i := range p.shards {
+		s := &p.shards[i]
+		s.mu.Lock()
+		for key := range s.results {
+			if match(key) {
+				delete(s.results, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// forgetKey forgets the pending result of key.
+func (p *pendingResults[Key, Value]) forgetKey(key Key) {
+	s := p.shard(key)
+	s.mu.Lock()
+	delete(s.results, key)
+	s.mu.Unlock()
+}
+
+// set sets the pending result of key. The shard must be locked.
+func (s *pendingShard[Key, Value]) set(key Key, result *pendingResult[Value]) {
+	if s.results == nil {
+		s.results = make(map[Key]*pendingResult[Value])
+	}
+	s.results[key] = result
+}
+
+// thunk returns the thunk cached at key for a caller whose context is ctx. If key is being loaded, it is
+// a thunk of its pending result, which returns ctx.Err() as soon as ctx is done. Otherwise the item has
+// resolved and the cached thunk is returned as is. The shard must be locked.
+func (s *pendingShard[Key, Value]) thunk(ctx context.Context, key Key, cached Thunk[Value]) Thunk[Value] {
+	if ctx.Done() == nil {
+		return cached
+	}
+	if result := s.results[key]; result != nil {
+		return result.thunk(ctx)
+	}
+	return cached
+}
+
+// isContextError reports whether err was caused by a cancelled or expired context.
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// isTransientError reports whether err is only due to the circumstances of a batch, like a cancelled
+// context or a full batch queue, so that the key shouldn't stay cached.
+func isTransientError(err error) bool {
+	var queueFull *BatchQueueFullError
+	return isContextError(err) || errors.As(err, &queueFull)
+}
@@ -0,0 +1,257 @@
+package dataloader
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RemoteStore is a store of bytes shared by many processes, e.g. Redis or Memcached.
+// It is the second tier of a TieredCache.
+type RemoteStore interface {
+	// Get returns the value at key and whether it was found
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// GetMulti returns the values found at keys
+	GetMulti(ctx context.Context, keys []string) (map[string][]byte, error)
+	// Set sets the value at key, expiring after ttl. A ttl of 0 means the value never expires.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete deletes the value at key
+	Delete(ctx context.Context, key string) error
+}
+
+// TieredCache is a two-tier implementation of the Cache interface. Thunks are cached in a local cache,
+// in front of a RemoteStore shared by every replica of the service, where results are stored encoded
+// like in an EncodedCache.
+//
+// The remote tier isn't looked up by Get: the keys of each batch are fetched from it at once, and only
+// the keys it misses are passed on to the batch function (see PrefetchCache). The values the batch
+// function returns, and the keys it didn't find, are then written to the remote tier (see WithRemoteErrors
+// for the other errors).
+type TieredCache[Key comparable, Value any] struct {
+	local  AtomicCache[Key, Value]
+	remote *EncodedCache[Key, Value]
+
+	mu sync.Mutex
+	// tags of the items in both tiers
+	tags tagIndex[Key]
+	// keys set in the local tier whose result is written to the remote tier once they resolve, unless
+	// they are deleted meanwhile or found in the remote tier by Prefetch
+	resolving map[Key]struct{}
+}
+
+// NewTieredCache constructs a new TieredCache in front of remote, serializing values with codec.
+func NewTieredCache[Key comparable, Value any](remote RemoteStore, codec Codec[Value], opts ...RemoteCacheOption[Key, Value]) *TieredCache[Key, Value] {
+	c := &TieredCache[Key, Value]{
+		local:     newRemoteCacheOptions(opts).local,
+		remote:    NewEncodedCache(remote, codec, opts...),
+		resolving: make(map[Key]struct{}),
+	}
+
+	if c.local == nil {
+		c.local = NewCache[Key, Value]()
+	}
+
+	return c
+}
+
+// Get gets the value at `key` from the local tier
+func (c *TieredCache[Key, Value]) Get(ctx context.Context, key Key) (Thunk[Value], bool) {
+	return c.local.Get(ctx, key)
+}
+
+// Set sets the `value` at `key` in the local tier
+func (c *TieredCache[Key, Value]) Set(ctx context.Context, key Key, value Thunk[Value]) {
+	c.local.Set(ctx, key, value)
+	c.mu.Lock()
+	c.tags.remove(key)
+	c.resolving[key] = struct{}{}
+	c.mu.Unlock()
+}
+
+// GetOrSet returns the value at `key` in the local tier and true if it exists, otherwise it sets
+// `value` at `key` and returns it and false
+func (c *TieredCache[Key, Value]) GetOrSet(ctx context.Context, key Key, value Thunk[Value]) (Thunk[Value], bool) {
+	thunk, loaded := c.local.GetOrSet(ctx, key, value)
+	if !loaded {
+		c.mu.Lock()
+		c.resolving[key] = struct{}{}
+		c.mu.Unlock()
+	}
+	return thunk, loaded
+}
+
+// Delete deletes item at `key` from both tiers
+func (c *TieredCache[Key, Value]) Delete(ctx context.Context, key Key) bool {
+	c.mu.Lock()
+	c.tags.remove(key)
+	delete(c.resolving, key)
+	c.mu.Unlock()
+	c.remote.Delete(ctx, key)
+	return c.local.Delete(ctx, key)
+}
+
+// Clear clears the local tier. The remote tier is shared with other processes and left untouched.
+func (c *TieredCache[Key, Value]) Clear() {
+	c.local.Clear()
+	c.mu.Lock()
+	c.tags.clear()
+	c.resolving = make(map[Key]struct{})
+	c.mu.Unlock()
+}
+
+// DeleteFunc deletes the items of the local tier whose key match reports true for from both tiers, and
+// returns how many it deleted. If the local tier can't delete keys by predicate (see PredicateCache), it is
+// cleared instead.
+func (c *TieredCache[Key, Value]) DeleteFunc(ctx context.Context, match func(Key) bool) int {
+	predicateCache, ok := c.local.(PredicateCache[Key, Value])
+	if !ok {
+		c.Clear()
+		return 0
+	}
+
+	var deleted []Key
+	predicateCache.DeleteFunc(ctx, func(key Key) bool {
+		if match(key) {
+			deleted = append(deleted, key)
+			return true
+		}
+		return false
+	})
+
+	c.mu.Lock()
+	for _, key := range deleted {
+		c.tags.remove(key)
+		delete(c.resolving, key)
+	}
+	c.mu.Unlock()
+
+	for _, key := range deleted {
+		c.remote.Delete(ctx, key)
+	}
+	return len(deleted)
+}
+
+// Tag gives tags to the item at `key`, if it exists in the local tier
+func (c *TieredCache[Key, Value]) Tag(ctx context.Context, key Key, tags []string) {
+	if _, found := c.local.Get(ctx, key); !found {
+		return
+	}
+	c.mu.Lock()
+	c.tags.add(key, tags)
+	c.mu.Unlock()
+}
+
+// DeleteTag deletes the items with `tag` from both tiers, and returns how many it deleted
+func (c *TieredCache[Key, Value]) DeleteTag(ctx context.Context, tag string) int {
+	c.mu.Lock()
+	keys := c.tags.take(tag)
+	c.mu.Unlock()
+
+	for _, key := range keys {
+		c.Delete(ctx, key)
+	}
+	return len(keys)
+}
+
+// Prefetch looks the keys up in the remote tier, returning the results of the keys it found
+// and nil for the others.
+func (c *TieredCache[Key, Value]) Prefetch(ctx context.Context, keys []Key) []*Result[Value] {
+	results := c.remote.getMany(ctx, keys)
+
+	c.mu.Lock()
+	for i, result := range results {
+		if result != nil {
+			delete(c.resolving, keys[i])
+		}
+	}
+	c.mu.Unlock()
+	return results
+}
+
+// Resolve writes the result `key` resolved to to the remote tier, unless it was read from it by Prefetch,
+// or `key` was deleted, or the cache cleared, since it was set
+func (c *TieredCache[Key, Value]) Resolve(ctx context.Context, key Key, result *Result[Value]) {
+	if resultCache, ok := c.local.(ResultCache[Key, Value]); ok {
+		resultCache.Resolve(ctx, key, result)
+	}
+
+	c.mu.Lock()
+	_, resolving := c.resolving[key]
+	delete(c.resolving, key)
+	c.mu.Unlock()
+
+	if resolving {
+		c.remote.write(ctx, key, result)
+	}
+}
+
+// MemoryStore is an in process implementation of the RemoteStore interface, meant for tests.
+type MemoryStore struct {
+	mu    sync.Mutex
+	items map[string]memoryStoreItem
+}
+
+type memoryStoreItem struct {
+	value   []byte
+	expires time.Time
+}
+
+// NewMemoryStore constructs a new MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{items: make(map[string]memoryStoreItem)}
+}
+
+// Get returns the value at key and whether it was found
+func (s *MemoryStore) Get(_ context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.get(key)
+	return value, ok, nil
+}
+
+// GetMulti returns the values found at keys
+func (s *MemoryStore) GetMulti(_ context.Context, keys []string) (map[string][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	values := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		if value, ok := s.get(key); ok {
+			values[key] = value
+		}
+	}
+	return values, nil
+}
+
+func (s *MemoryStore) get(key string) ([]byte, bool) {
+	item, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	if !item.expires.IsZero() && time.Now().After(item.expires) {
+		delete(s.items, key)
+		return nil, false
+	}
+	return append([]byte(nil), item.value...), true
+}
+
+// Set sets the value at key, expiring after ttl
+func (s *MemoryStore) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	item := memoryStoreItem{value: append([]byte(nil), value...)}
+	if ttl > 0 {
+		item.expires = time.Now().Add(ttl)
+	}
+
+	s.mu.Lock()
+	s.items[key] = item
+	s.mu.Unlock()
+	return nil
+}
+
+// Delete deletes the value at key
+func (s *MemoryStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	delete(s.items, key)
+	s.mu.Unlock()
+	return nil
+}
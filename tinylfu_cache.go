@@ -0,0 +1,288 @@
+package dataloader
+
+import (
+	"context"
+	"hash/maphash"
+	"sync"
+)
+
+// TinyLFUCache is an in memory implementation of the Cache interface bounded in size.
+// It keeps items in least recently used order, but only admits a new item once the cache is full
+// if it is estimated to be accessed more often than the item it would evict. This protects the
+// frequently used items from being evicted by bursts of items which are only used once.
+//
+// The items set by GetOrSet which aren't admitted are kept aside until they resolve (see Resolve),
+// so that concurrent loads of their key still share them. Up to capacity items are kept aside:
+// beyond that, they are rejected at once.
+type TinyLFUCache[Key comparable, Value any] struct {
+	mu     sync.Mutex
+	lru    *lruList[Key, Value]
+	sketch *frequencySketch
+	hash   func(Key) uint64
+	stats  CacheStats
+	// items waiting for their result to be admitted
+	pending map[Key]Thunk[Value]
+	boundedCacheOptions[Key, Value]
+}
+
+// NewTinyLFUCache constructs a new TinyLFUCache holding up to capacity items
+// (or items of capacity total cost, see WithCost).
+func NewTinyLFUCache[Key comparable, Value any](capacity int64, opts ...BoundedCacheOption[Key, Value]) *TinyLFUCache[Key, Value] {
+	seed := maphash.MakeSeed()
+	c := &TinyLFUCache[Key, Value]{
+		lru:    newLRUList[Key, Value](capacity),
+		sketch: newFrequencySketch(capacity),
+		hash:   func(key Key) uint64 { return hashKey(seed, key) },
+	}
+	for _, apply := range opts {
+		apply(&c.boundedCacheOptions)
+	}
+	return c
+}
+
+// Get gets the value at `key` if it exsits, returns value (or nil) and bool
+// indicating of value was found
+func (c *TinyLFUCache[Key, Value]) Get(_ context.Context, key Key) (Thunk[Value], bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sketch.increment(c.hash(key))
+	return c.get(key)
+}
+
+// get returns the value at key, counting the hit or miss. It must be called with the lock held.
+func (c *TinyLFUCache[Key, Value]) get(key Key) (Thunk[Value], bool) {
+	if item, found := c.lru.get(key); found {
+		c.stats.Hits++
+		return item.thunk, true
+	}
+	if value, found := c.pending[key]; found {
+		c.stats.Hits++
+		return value, true
+	}
+	c.stats.Misses++
+	return nil, false
+}
+
+// Set sets the `value` at `key` in the cache. If the cache is full, the value is only stored if `key`
+// is accessed more often than the least recently used item, which is then evicted.
+func (c *TinyLFUCache[Key, Value]) Set(_ context.Context, key Key, value Thunk[Value]) {
+	c.mu.Lock()
+	delete(c.pending, key)
+	evicted := c.set(key, value)
+	c.mu.Unlock()
+
+	c.evicted(evicted)
+}
+
+// GetOrSet returns the value at `key` and true if it exists, otherwise it sets `value` at `key`
+// and returns it and false. If the cache is full and `key` isn't admitted yet, `value` is kept
+// aside until it resolves, when it is admitted or rejected like in Set (see Resolve), unless too
+// many items are kept aside already, in which case it is rejected.
+func (c *TinyLFUCache[Key, Value]) GetOrSet(_ context.Context, key Key, value Thunk[Value]) (Thunk[Value], bool) {
+	c.mu.Lock()
+	c.sketch.increment(c.hash(key))
+	if v, found := c.get(key); found {
+		c.mu.Unlock()
+		return v, true
+	}
+	if !c.admitted(key) {
+		if int64(len(c.pending)) >= c.lru.capacity {
+			c.stats.Rejections++
+			c.mu.Unlock()
+			return value, false
+		}
+		if c.pending == nil {
+			c.pending = make(map[Key]Thunk[Value])
+		}
+		c.pending[key] = value
+		c.mu.Unlock()
+		return value, false
+	}
+	evicted := c.set(key, value)
+	c.mu.Unlock()
+
+	c.evicted(evicted)
+	return value, false
+}
+
+// set stores value at key if it is admitted, and returns the evicted items. It must be called with the lock held.
+func (c *TinyLFUCache[Key, Value]) set(key Key, value Thunk[Value]) []*lruItem[Key, Value] {
+	if !c.admitted(key) {
+		c.stats.Rejections++
+		return nil
+	}
+	c.lru.set(key, value)
+	evicted := c.lru.evict()
+	c.stats.Evictions += uint64(len(evicted))
+	return evicted
+}
+
+// admitted reports whether key may be stored: it already is, the cache isn't full, or it is
+// used more often than the item it would evict. It must be called with the lock held.
+func (c *TinyLFUCache[Key, Value]) admitted(key Key) bool {
+	if _, found := c.lru.items[key]; found || !c.lru.full(1) {
+		return true
+	}
+	victim := c.lru.oldest()
+	return victim == nil || c.admit(key, victim.key)
+}
+
+// admit reports whether candidate is used more often than victim.
+func (c *TinyLFUCache[Key, Value]) admit(candidate, victim Key) bool {
+	return c.sketch.estimate(c.hash(candidate)) > c.sketch.estimate(c.hash(victim))
+}
+
+// Resolve admits or rejects the item at `key` if it was kept aside by GetOrSet, and updates its cost from
+// the result it resolved to if a cost function is set.
+func (c *TinyLFUCache[Key, Value]) Resolve(_ context.Context, key Key, result *Result[Value]) {
+	c.mu.Lock()
+	var evicted []*lruItem[Key, Value]
+	if value, found := c.pending[key]; found {
+		delete(c.pending, key)
+		evicted = c.set(key, value)
+	}
+	if c.cost != nil {
+		c.lru.setCost(key, c.cost(key, result))
+		items := c.lru.evict()
+		c.stats.Evictions += uint64(len(items))
+		evicted = append(evicted, items...)
+	}
+	c.mu.Unlock()
+
+	c.evicted(evicted)
+}
+
+// Delete deletes item at `key` from cache
+func (c *TinyLFUCache[Key, Value]) Delete(_ context.Context, key Key) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, pending := c.pending[key]
+	delete(c.pending, key)
+	return c.lru.remove(key) || pending
+}
+
+// Clear clears the entire cache. The access frequencies are kept.
+func (c *TinyLFUCache[Key, Value]) Clear() {
+	c.mu.Lock()
+	c.lru.clear()
+	c.pending = nil
+	c.mu.Unlock()
+}
+
+// DeleteFunc deletes the items whose key match reports true for, and returns how many it deleted
+func (c *TinyLFUCache[Key, Value]) DeleteFunc(_ context.Context, match func(Key) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	deleted := c.lru.removeFunc(match)
+	for key := range c.pending {
+		if match(key) {
+			delete(c.pending, key)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+// Tag gives tags to the item at `key`, if it exists
+func (c *TinyLFUCache[Key, Value]) Tag(_ context.Context, key Key, tags []string) {
+	c.mu.Lock()
+	c.lru.tag(key, tags)
+	c.mu.Unlock()
+}
+
+// DeleteTag deletes the items with `tag`, and returns how many it deleted
+func (c *TinyLFUCache[Key, Value]) DeleteTag(_ context.Context, tag string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.removeTag(tag)
+}
+
+// Len returns the number of items in the cache
+func (c *TinyLFUCache[Key, Value]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.lru.items)
+}
+
+// Stats returns the counters of the cache
+func (c *TinyLFUCache[Key, Value]) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+const (
+	sketchDepth    = 4
+	sketchMaxCount = 15
+)
+
+// frequencySketch is a count-min sketch estimating how often keys are accessed. Its counters are
+// halved periodically so that the estimates favour recent accesses. It isn't safe for concurrent use.
+type frequencySketch struct {
+	counters  [sketchDepth][]uint8
+	mask      uint64
+	additions int
+	resetAt   int
+}
+
+func newFrequencySketch(capacity int64) *frequencySketch {
+	width := uint64(16)
+	for width < uint64(capacity) {
+		width <<= 1
+	}
+
+	s := &frequencySketch{
+		mask:    width - 1,
+		resetAt: 10 * int(width),
+	}
+	for i := range s.counters {
+		s.counters[i] = make([]uint8, width)
+	}
+	return s
+}
+
+// sketchSeeds are the seeds of the rows of the sketch, so that a key is mapped to independent
+// counters in every row.
+var sketchSeeds = [sketchDepth]uint64{0xc3a5c85c97cb3127, 0xb492b66fbe98f273, 0x9ae16a3b2f90404f, 0xcbf29ce484222325}
+
+// index returns the counter of hash in row.
+func (s *frequencySketch) index(hash uint64, row int) uint64 {
+	h := (hash + sketchSeeds[row]) * sketchSeeds[row]
+	h += h >> 32
+	return h & s.mask
+}
+
+func (s *frequencySketch) increment(hash uint64) {
+	for row := range s.counters {
+		i := s.index(hash, row)
+		if s.counters[row][i] < sketchMaxCount {
+			s.counters[row][i]++
+		}
+	}
+
+	s.additions++
+	if s.additions >= s.resetAt {
+		s.reset()
+	}
+}
+
+func (s *frequencySketch) estimate(hash uint64) uint8 {
+	lowest := uint8(sketchMaxCount)
+	for row := range s.counters {
+		if count := s.counters[row][s.index(hash, row)]; count < lowest {
+			lowest = count
+		}
+	}
+	return lowest
+}
+
+// reset halves every counter
+func (s *frequencySketch) reset() {
+	for row := range s.counters {
+		for i := range s.counters[row] {
+			s.counters[row][i] >>= 1
+		}
+	}
+	s.additions = 0
+}
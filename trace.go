@@ -20,6 +20,12 @@ type Tracer[Key any, Value any] interface {
 	TraceBatch(ctx context.Context, keys []Key) (context.Context, TraceBatchFinishFunc[Value])
 }
 
+// CacheTracer may be implemented by a Tracer to trace the calls to Load which were served by the
+// cache. stale is true if the item was stale and its key is being reloaded (see StaleCache).
+type CacheTracer[Key any] interface {
+	TraceCacheHit(ctx context.Context, key Key, stale bool)
+}
+
 // NoopTracer is the default (noop) tracer
 type NoopTracer[Key any, Value any] struct{}
 
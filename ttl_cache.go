@@ -0,0 +1,312 @@
+package dataloader
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TTLCache is an in memory implementation of the Cache interface whose items expire.
+// Unlike InMemoryCache it is suited for long lived loaders. Expired items are removed
+// when they are accessed, or in the background if a janitor is started (see WithJanitor).
+type TTLCache[Key comparable, Value any] struct {
+	items map[Key]*ttlItem[Value]
+	tags  tagIndex[Key]
+	mu    sync.Mutex
+
+	// the default time to live of items. Set to 0 if you want them to never expire.
+	ttl time.Duration
+	// should getting an item extend its expiry by its time to live?
+	sliding bool
+	// time to live of the items that resolved to a not found result
+	notFoundTTL time.Duration
+	// time to live derived from the result an item resolved to
+	resultTTL func(*Result[Value]) time.Duration
+	// how long items are kept stale once their time to live has passed
+	staleTTL time.Duration
+
+	// used to stop the janitor
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+type ttlItem[Value any] struct {
+	thunk   Thunk[Value]
+	ttl     time.Duration
+	stale   time.Duration
+	expires time.Time
+	// number of times the item was got since it was set
+	hits int
+}
+
+func (i *ttlItem[Value]) expired(now time.Time) bool {
+	return i.ttl > 0 && now.After(i.expires.Add(i.stale))
+}
+
+func (i *ttlItem[Value]) isStale(now time.Time) bool {
+	return i.ttl > 0 && now.After(i.expires)
+}
+
+// usage counts a hit on the item, extending its expiry if sliding, and returns its usage.
+func (i *ttlItem[Value]) usage(now time.Time, sliding bool) CacheUsage {
+	i.hits++
+	stale := i.isStale(now)
+	if sliding && !stale {
+		i.expires = now.Add(i.ttl)
+	}
+
+	usage := CacheUsage{Hits: i.hits, TTLLeft: 1, Stale: stale}
+	if i.ttl > 0 {
+		usage.TTLLeft = float64(i.expires.Sub(now)) / float64(i.ttl)
+	}
+	return usage
+}
+
+// TTLCacheOption allows for configuration of TTLCache fields.
+type TTLCacheOption[Key comparable, Value any] func(*TTLCache[Key, Value])
+
+// WithSlidingExpiration makes getting an item extend its expiry by its time to live,
+// so that only items which aren't used expire.
+func WithSlidingExpiration[Key comparable, Value any]() TTLCacheOption[Key, Value] {
+	return func(c *TTLCache[Key, Value]) {
+		c.sliding = true
+	}
+}
+
+// WithJanitor starts a goroutine removing the expired items every interval.
+// It runs until the cache is stopped (see TTLCache.Stop).
+func WithJanitor[Key comparable, Value any](interval time.Duration) TTLCacheOption[Key, Value] {
+	return func(c *TTLCache[Key, Value]) {
+		c.stop = make(chan struct{})
+		go c.janitor(interval, c.stop)
+	}
+}
+
+// WithNotFoundTTL sets the time to live of the items that resolved to a not found result
+// (see ErrNotFound), which is usually shorter than the one of the items that exist.
+func WithNotFoundTTL[Key comparable, Value any](ttl time.Duration) TTLCacheOption[Key, Value] {
+	return func(c *TTLCache[Key, Value]) {
+		c.notFoundTTL = ttl
+	}
+}
+
+// WithResultTTL derives the time to live of an item from the result it resolved to.
+// If fn returns 0, the default time to live is used.
+func WithResultTTL[Key comparable, Value any](fn func(*Result[Value]) time.Duration) TTLCacheOption[Key, Value] {
+	return func(c *TTLCache[Key, Value]) {
+		c.resultTTL = fn
+	}
+}
+
+// WithStaleTTL keeps items for a further ttl once their time to live has passed, during which
+// they are stale: Get still finds them, and a Loader returns them from Load at once while it
+// reloads their key in the background (see StaleCache).
+func WithStaleTTL[Key comparable, Value any](ttl time.Duration) TTLCacheOption[Key, Value] {
+	return func(c *TTLCache[Key, Value]) {
+		c.staleTTL = ttl
+	}
+}
+
+// NewTTLCache constructs a new TTLCache whose items expire after ttl by default.
+func NewTTLCache[Key comparable, Value any](ttl time.Duration, opts ...TTLCacheOption[Key, Value]) *TTLCache[Key, Value] {
+	c := &TTLCache[Key, Value]{
+		items: make(map[Key]*ttlItem[Value]),
+		ttl:   ttl,
+	}
+
+	for _, apply := range opts {
+		apply(c)
+	}
+
+	return c
+}
+
+// Set sets the `value` at `key` in the cache, with the default time to live
+func (c *TTLCache[Key, Value]) Set(ctx context.Context, key Key, value Thunk[Value]) {
+	c.SetWithTTL(ctx, key, value, c.ttl)
+}
+
+// SetWithTTL sets the `value` at `key` in the cache, expiring after ttl.
+// Set ttl to 0 if you want it to never expire.
+func (c *TTLCache[Key, Value]) SetWithTTL(_ context.Context, key Key, value Thunk[Value], ttl time.Duration) {
+	c.mu.Lock()
+	c.items[key] = &ttlItem[Value]{
+		thunk:   value,
+		ttl:     ttl,
+		stale:   c.staleTTL,
+		expires: time.Now().Add(ttl),
+	}
+	c.tags.remove(key)
+	c.mu.Unlock()
+}
+
+// Get gets the value at `key` if it exists and hasn't expired, returns value (or nil) and bool
+// indicating of value was found
+func (c *TTLCache[Key, Value]) Get(ctx context.Context, key Key) (Thunk[Value], bool) {
+	thunk, _, found := c.GetUsage(ctx, key)
+	return thunk, found
+}
+
+// GetStale is like Get, but also reports whether the value is stale (see WithStaleTTL).
+// Getting a stale value doesn't extend its expiry.
+func (c *TTLCache[Key, Value]) GetStale(ctx context.Context, key Key) (Thunk[Value], bool, bool) {
+	thunk, usage, found := c.GetUsage(ctx, key)
+	return thunk, found, usage.Stale
+}
+
+// GetUsage is like Get, but also returns how many times the value was got since it was set
+// and which fraction of its time to live is left.
+func (c *TTLCache[Key, Value]) GetUsage(_ context.Context, key Key) (Thunk[Value], CacheUsage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, found := c.items[key]
+	if !found {
+		return nil, CacheUsage{}, false
+	}
+
+	now := time.Now()
+	if item.expired(now) {
+		delete(c.items, key)
+		c.tags.remove(key)
+		return nil, CacheUsage{}, false
+	}
+
+	return item.thunk, item.usage(now, c.sliding), true
+}
+
+// GetOrSet returns the value at `key` and true if it exists and hasn't expired, otherwise it sets
+// `value` at `key` with the default time to live and returns it and false
+func (c *TTLCache[Key, Value]) GetOrSet(_ context.Context, key Key, value Thunk[Value]) (Thunk[Value], bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if item, found := c.items[key]; found && !item.expired(now) {
+		item.usage(now, c.sliding)
+		return item.thunk, true
+	}
+
+	c.items[key] = &ttlItem[Value]{
+		thunk:   value,
+		ttl:     c.ttl,
+		stale:   c.staleTTL,
+		expires: now.Add(c.ttl),
+	}
+	c.tags.remove(key)
+	return value, false
+}
+
+// Resolve updates the time to live of the item at `key` from the result it resolved to,
+// if a not found or a result time to live is configured.
+func (c *TTLCache[Key, Value]) Resolve(_ context.Context, key Key, result *Result[Value]) {
+	var ttl time.Duration
+	switch {
+	case c.notFoundTTL > 0 && result.NotFound():
+		ttl = c.notFoundTTL
+	case c.resultTTL != nil:
+		ttl = c.resultTTL(result)
+	}
+	if ttl == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	if item, found := c.items[key]; found {
+		item.ttl = ttl
+		item.expires = time.Now().Add(ttl)
+	}
+	c.mu.Unlock()
+}
+
+// Delete deletes item at `key` from cache
+func (c *TTLCache[Key, Value]) Delete(_ context.Context, key Key) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, found := c.items[key]
+	if !found {
+		return false
+	}
+	delete(c.items, key)
+	c.tags.remove(key)
+	return !item.expired(time.Now())
+}
+
+// Clear clears the entire cache
+func (c *TTLCache[Key, Value]) Clear() {
+	c.mu.Lock()
+	c.items = map[Key]*ttlItem[Value]{}
+	c.tags.clear()
+	c.mu.Unlock()
+}
+
+// DeleteFunc deletes the items whose key match reports true for, and returns how many it deleted
+func (c *TTLCache[Key, Value]) DeleteFunc(_ context.Context, match func(Key) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	deleted := 0
+	for key := range c.items {
+		if match(key) {
+			delete(c.items, key)
+			c.tags.remove(key)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+// Tag gives tags to the item at `key`, if it exists
+func (c *TTLCache[Key, Value]) Tag(_ context.Context, key Key, tags []string) {
+	c.mu.Lock()
+	if _, found := c.items[key]; found {
+		c.tags.add(key, tags)
+	}
+	c.mu.Unlock()
+}
+
+// DeleteTag deletes the items with `tag`, and returns how many it deleted
+func (c *TTLCache[Key, Value]) DeleteTag(_ context.Context, tag string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := c.tags.take(tag)
+	for _, key := range keys {
+		delete(c.items, key)
+	}
+	return len(keys)
+}
+
+// Stop stops the janitor, if one was started. The cache can still be used afterwards.
+func (c *TTLCache[Key, Value]) Stop() {
+	if c.stop != nil {
+		c.stopOnce.Do(func() { close(c.stop) })
+	}
+}
+
+// removeExpired removes all the expired items
+func (c *TTLCache[Key, Value]) removeExpired() {
+	c.mu.Lock()
+	now := time.Now()
+	for key, item := range c.items {
+		if item.expired(now) {
+			delete(c.items, key)
+			c.tags.remove(key)
+		}
+	}
+	c.mu.Unlock()
+}
+
+func (c *TTLCache[Key, Value]) janitor(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			c.removeExpired()
+		}
+	}
+}